@@ -30,7 +30,7 @@ func main() {
 
 	// create a chat for our users
 	userIDs := []string{userOneID, userTwoID}
-	nRsp, err := chatCli.New(context.TODO(), &chat.NewRequest{UserIds: userIDs})
+	nRsp, err := chatCli.New(context.TODO(), &chat.NewRequest{UserIds: userIDs, CreatorId: userOneID})
 	if err != nil {
 		logger.Fatalf("Error creating the chat: %v", err)
 	}
@@ -38,7 +38,7 @@ func main() {
 	logger.Infof("Chat Created. ID: %v", chatID)
 
 	// list the number messages in the chat history
-	hRsp, err := chatCli.History(context.TODO(), &chat.HistoryRequest{ChatId: chatID})
+	hRsp, err := chatCli.History(context.TODO(), &chat.HistoryRequest{ChatId: chatID, UserId: userOneID})
 	if err != nil {
 		logger.Fatalf("Error getting the chat history: %v", err)
 	}
@@ -61,10 +61,10 @@ func main() {
 		for i := 1; true; i++ {
 			// send a message to the chat
 			err = stream.Send(&chat.Message{
-				ClientId: uuid.New().String(),
-				SentAt:   time.Now().Unix(),
-				Subject:  "Message from user one",
-				Text:     fmt.Sprintf("Message #%v", i),
+				ClientId:     uuid.New().String(),
+				ClientSentAt: time.Now().UnixNano() / int64(time.Millisecond),
+				Subject:      "Message from user one",
+				Text:         fmt.Sprintf("Message #%v", i),
 			})
 			if err != nil {
 				errChan <- err
@@ -97,10 +97,10 @@ func main() {
 		for i := 1; true; i++ {
 			// send a response to the chat
 			err = stream.Send(&chat.Message{
-				ClientId: uuid.New().String(),
-				SentAt:   time.Now().Unix(),
-				Subject:  "Response from user two",
-				Text:     fmt.Sprintf("Response #%v", i),
+				ClientId:     uuid.New().String(),
+				ClientSentAt: time.Now().UnixNano() / int64(time.Millisecond),
+				Subject:      "Response from user two",
+				Text:         fmt.Sprintf("Response #%v", i),
 			})
 			if err != nil {
 				errChan <- err
@@ -119,8 +119,8 @@ func main() {
 		}
 	}()
 
-	//remove chat
-	chatCli.Remove(context.TODO(), &chat.RemoveRequest{ChatId: chatID})
+	//remove chat, this hides it from user one without affecting user two
+	chatCli.Remove(context.TODO(), &chat.RemoveRequest{ChatId: chatID, UserId: userOneID})
 
 	logger.Fatal(<-errChan)
 }
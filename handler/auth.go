@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/auth"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+)
+
+// userAccountType is the type of the accounts issued to end users. Any other account, such as the
+// ones issued to services like the api gateway or the blank accounts issued by noop auth during
+// development, is trusted to act on behalf of the user it passes.
+const userAccountType = "user"
+
+// identify returns the id of the user making the request. userID is the user the client claims to be
+// acting as, users can only ever act as themselves however other services can act as any user. The
+// id passed is used as the prefix of the errors returned, e.g. "chat.History".
+func identify(ctx context.Context, id, userID string) (string, error) {
+	acc, ok := auth.AccountFromContext(ctx)
+	if !ok {
+		return "", errors.Unauthorized(id+".Unauthorized", "An account is required")
+	}
+	if acc.Type != userAccountType {
+		return userID, nil
+	}
+	if len(userID) > 0 && userID != acc.ID {
+		return "", errors.Forbidden(id+".Forbidden", "Not allowed to act as another user")
+	}
+	return acc.ID, nil
+}
+
+// authorize loads the chat and ensures the user is part of it. The id passed is used as the prefix
+// of the errors returned.
+func authorize(id, chatID, userID string) (*pb.ChatInfo, error) {
+	chat, err := readChat(chatID)
+	if err == store.ErrNotFound {
+		return nil, errors.BadRequest(id+".InvalidChatID", "Chat not found with this ID")
+	} else if err != nil {
+		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
+		return nil, errors.InternalServerError(id+".Unknown", "Error reading from the store")
+	}
+
+	// chats created before their users were recorded can't be authorized, so nobody is allowed to
+	// access them until New is called again for their users, which records them
+	if !containsString(chat.UserIds, userID) {
+		return nil, errors.Forbidden(id+".Forbidden", "Not a member of this chat")
+	}
+	return chat, nil
+}
@@ -5,115 +5,79 @@ import (
 
 	pb "github.com/micro-community/micro-chat/proto"
 	"github.com/micro/micro/v3/service/context/metadata"
-	"github.com/micro/micro/v3/service/errors"
-	"github.com/micro/micro/v3/service/events"
-	"github.com/micro/micro/v3/service/logger"
-	"github.com/micro/micro/v3/service/store"
 )
 
 // Connect to server enter chat room
 func (c *Chat) Connect(ctx context.Context, stream pb.Chat_ConnectStream) error {
-	// the client passed the chat id and user id in the request context. we'll load that information
-	// now and validate it. If any information is missing we'll return a BadRequest error to the client
-	userID, ok := metadata.Get(ctx, "user-id")
-	if !ok {
-		return errors.BadRequest("chat.Connect.MissingUserID", "UserID missing in context")
-	}
-	chatID, ok := metadata.Get(ctx, "chat-id")
-	if !ok {
-		return errors.BadRequest("chat.Connect.MissingChatID", "ChatId missing in context")
-	}
-
-	// lookup the chat from the store to ensure it's valid
-	if _, err := store.Read(chatStoreKeyPrefix + chatID); err == store.ErrNotFound {
-		return errors.BadRequest("chat.Connect.InvalidChatID", "Chat not found with this ID")
-	} else if err != nil {
-		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
-		return errors.InternalServerError("chat.Connect.Unknown", "Error reading from the store")
+	s, err := connect(ctx, "chat.Connect")
+	if err != nil {
+		return err
 	}
 
-	// as per the New and Connect functions, at this point in a real world application we would
-	// authorize the request to ensure the authenticated user is part of the chat they're attempting
-	// to read the history of
-
-	// create a new context which can be cancelled, in the case either the consumer of publisher errors
-	// we don't want one to keep running in the background
-	cancelCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	// clients written before messages were acknowledged would treat the acknowledgement as a new
+	// message, so they have to ask for them by passing "acks: true" in the metadata
+	acks, _ := metadata.Get(ctx, "acks")
 
-	// create a channel to send errors on, because the subscriber / publisher will run in separate go-
-	// routines, they need a way of returning errors to the client
-	errChan := make(chan error)
-
-	// create an event stream to consume messages posted by other users into the chat. we'll use the
-	// user id as a queue to ensure each user receives the message
-	evStream, err := events.Consume(chatEventKeyPrefix+chatID, events.WithGroup(userID))
-	if err != nil {
-		logger.Errorf("Error streaming events. Chat ID: %v. Error: %v", chatID, err)
-		return errors.InternalServerError("chat.Connect.Unknown", "Error connecting to the event stream")
-	}
-	go func() {
-		for {
-			select {
-			case <-cancelCtx.Done():
-				// the context has been cancelled or timed out, stop subscribing to new messages
-				return
-			case ev := <-evStream:
-				// received a message, unmarshal it into a message struct. if an error occurs log it and
-				// cancel the context
-				var msg pb.Message
-				if err := ev.Unmarshal(&msg); err != nil {
-					logger.Errorf("Error unmarshaling message. ChatID: %v. Error: %v", chatID, err)
-					errChan <- err
-					return
-				}
+	// Connect shares the session with ConnectV2, the stream only carries messages so it's adapted to
+	// send and receive events
+	s.conn = &messageConn{stream: stream, userID: s.userID, acks: acks == "true"}
+	return c.serve(ctx, s)
+}
 
-				// ignore any messages published by the current user
-				if msg.UserId == userID {
-					continue
-				}
+// messageConn adapts the stream used by Connect, which only carries messages, to a connection
+type messageConn struct {
+	stream pb.Chat_ConnectStream
+	userID string
+	// acks is true if the client asked for its messages to be acknowledged
+	acks bool
+}
 
-				// publish the message to the stream
-				if err := stream.Send(&msg); err != nil {
-					logger.Errorf("Error sending message to stream. ChatID: %v. Message ID: %v. Error: %v", chatID, msg.Id, err)
-					errChan <- err
-					return
-				}
-			}
+// Send the message carried by the event to the client. Clients using Connect have always received
+// edits and deletes as the message itself. Acknowledgements are sent as the message acknowledged,
+// with the id and time allocated by the server, and the messages which couldn't be created are sent
+// back with the error set. Any other event is dropped.
+func (m *messageConn) Send(ev *pb.ChatEvent) error {
+	var msg *pb.Message
+	switch e := ev.Event.(type) {
+	case *pb.ChatEvent_Message:
+		msg = e.Message
+	case *pb.ChatEvent_Edit:
+		msg = e.Edit
+	case *pb.ChatEvent_Delete:
+		msg = e.Delete
+	case *pb.ChatEvent_Ack:
+		if !m.acks {
+			return nil
 		}
-	}()
-
-	// transform the stream.Recv into a channel which can be used in the select statement below
-	msgChan := make(chan *pb.Message)
-	go func() {
-		for {
-			msg, err := stream.Recv()
-			if err != nil {
-				errChan <- err
-				close(msgChan)
-				return
-			}
-			msgChan <- msg
+		msg = &pb.Message{
+			Id:       e.Ack.MessageId,
+			ClientId: e.Ack.ClientId,
+			ChatId:   ev.ChatId,
+			UserId:   m.userID,
+			SentAt:   e.Ack.SentAt,
 		}
-	}()
-
-	for {
-		select {
-		case <-cancelCtx.Done():
-			// the context has been cancelled or timed out, stop subscribing to new messages
+	case *pb.ChatEvent_Error:
+		// only the errors for messages can be matched up by the client
+		if !m.acks || len(e.Error.ClientId) == 0 {
 			return nil
-		case err := <-errChan:
-			// an error occurred in another goroutine, terminate the stream
-			return err
-		case msg := <-msgChan:
-			// set the defaults
-			msg.UserId = userID
-			msg.ChatId = chatID
-
-			// create the message
-			if err := c.createMessage(msg); err != nil {
-				return err
-			}
 		}
+		msg = &pb.Message{
+			ClientId: e.Error.ClientId,
+			ChatId:   ev.ChatId,
+			UserId:   m.userID,
+			Error:    e.Error,
+		}
+	default:
+		return nil
+	}
+	return m.stream.Send(msg)
+}
+
+// Recv a message from the client, wrapped in an event
+func (m *messageConn) Recv() (*pb.ChatEvent, error) {
+	msg, err := m.stream.Recv()
+	if err != nil {
+		return nil, err
 	}
+	return &pb.ChatEvent{ChatId: msg.ChatId, Event: &pb.ChatEvent_Message{Message: msg}}, nil
 }
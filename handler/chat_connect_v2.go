@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"context"
+
+	pb "github.com/micro-community/micro-chat/proto"
+)
+
+// ConnectV2 to a chat. It works the same way as Connect, however events are sent in both directions
+// rather than messages, so the stream can carry edits, deletes and the other realtime events of the
+// chat as well as new messages. Clients send messages, typing indicators and read receipts, any other
+// event sent results in an error event being returned.
+func (c *Chat) ConnectV2(ctx context.Context, stream pb.Chat_ConnectV2Stream) error {
+	s, err := connect(ctx, "chat.ConnectV2")
+	if err != nil {
+		return err
+	}
+
+	s.conn = stream
+	return c.serve(ctx, s)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// DeleteMessage retracts a message. The content of the message is purged, leaving a tombstone in its
+// place in the history, and the tombstone is published to the chat so connected users can remove the
+// message.
+func (c *Chat) DeleteMessage(ctx context.Context, req *pb.DeleteMessageRequest, rsp *pb.DeleteMessageResponse) error {
+	// identify the user deleting the message
+	userID, err := identify(ctx, "chat.DeleteMessage", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.MessageId) == 0 {
+		return errors.BadRequest("chat.DeleteMessage.MissingMessageID", "MessageID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.DeleteMessage.MissingUserID", "UserID is missing")
+	}
+
+	// deleting is serialised with editing the message, so an edit can't restore the content
+	unlock, err := c.lock("chat.DeleteMessage", revisionStoreKeyPrefix+req.MessageId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	msg, err := c.readMessage("chat.DeleteMessage", req.MessageId, userID)
+	if err != nil {
+		return err
+	}
+	if msg.Deleted {
+		rsp.Message = msg
+		return nil
+	}
+	if msg.System {
+		return errors.BadRequest("chat.DeleteMessage.SystemMessage", "System messages can't be deleted")
+	}
+
+	// only the sender of the message or an owner or admin of the chat can delete it
+	chat, err := readChat(msg.ChatId)
+	if err != nil {
+		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", msg.ChatId, err)
+		return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error reading from the store")
+	}
+	if msg.UserId != userID && !canManage(chat, userID) {
+		return errors.Forbidden("chat.DeleteMessage.Forbidden", "Only the sender or an owner or admin of the chat can delete a message")
+	}
+
+	// replace the message with a tombstone. The previous revisions and the search index entries are
+	// purged along with the content, and so is the copy of the message recorded against its client id.
+	msg.Subject = ""
+	msg.Text = ""
+	msg.Deleted = true
+	msg.DeletedAt = unixMillis(time.Now())
+	msg.DeletedBy = userID
+	if err := c.repo.Update(msg); err != nil {
+		logger.Errorf("Error updating the message. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error updating the message")
+	}
+	if err := c.indexMessage(msg); err != nil {
+		logger.Errorf("Error indexing the message. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error indexing the message")
+	}
+	if err := deleteRevisions(msg.Id); err != nil {
+		logger.Errorf("Error deleting from the store. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error deleting from the store")
+	}
+	if err := writeSentMessage(sentMessageKey(msg), &sentMessage{Message: msg, Published: true}); err != nil {
+		logger.Errorf("Error writing to the store. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error writing to the store")
+	}
+
+	// deleted replies aren't counted in their thread
+	if len(msg.ThreadRootId) > 0 {
+		if err := c.countReply(msg.ThreadRootId, -1); err != nil {
+			logger.Errorf("Error updating the message. Message ID: %v. Error: %v", msg.ThreadRootId, err)
+			return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error updating the message")
+		}
+	}
+
+	// notify the connected users of the retraction. The original event can't be removed from the
+	// append-only event stream, however the history is read from the messages table so it only ever
+	// returns the tombstone.
+	if err := publishMessage(eventTypeDelete, msg, ""); err != nil {
+		logger.Errorf("Error publishing the deletion. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.DeleteMessage.Unknown", "Error publishing the deletion")
+	}
+
+	rsp.Message = msg
+	return nil
+}
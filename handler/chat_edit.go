@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/micro-community/micro-chat/model"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// Edit a message, only the user who sent a message can edit it. The previous content of the message
+// is kept as a revision and the edited message is published to the chat so connected users can
+// update it in place.
+func (c *Chat) Edit(ctx context.Context, req *pb.EditRequest, rsp *pb.EditResponse) error {
+	// identify the user editing the message
+	userID, err := identify(ctx, "chat.Edit", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.MessageId) == 0 {
+		return errors.BadRequest("chat.Edit.MissingMessageID", "MessageID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.Edit.MissingUserID", "UserID is missing")
+	}
+	if len(req.Text) == 0 {
+		return errors.BadRequest("chat.Edit.MissingText", "Text is missing")
+	}
+
+	// edits of the same message are serialised so no revision is lost
+	unlock, err := c.lock("chat.Edit", revisionStoreKeyPrefix+req.MessageId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	msg, err := c.readMessage("chat.Edit", req.MessageId, userID)
+	if err != nil {
+		return err
+	}
+	if msg.UserId != userID {
+		return errors.Forbidden("chat.Edit.Forbidden", "Only the sender can edit a message")
+	}
+	if msg.System {
+		return errors.BadRequest("chat.Edit.SystemMessage", "System messages can't be edited")
+	}
+	if msg.Deleted {
+		return errors.BadRequest("chat.Edit.MessageDeleted", "The message has been deleted")
+	}
+
+	// record the current content of the message as a revision before replacing it
+	editedAt := unixMillis(time.Now())
+	rev := &pb.MessageRevision{MessageId: msg.Id, Subject: msg.Subject, Text: msg.Text, EditedAt: editedAt}
+	if err := writeRevision(rev); err != nil {
+		logger.Errorf("Error writing to the store. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.Edit.Unknown", "Error writing to the store")
+	}
+
+	msg.Subject = req.Subject
+	msg.Text = req.Text
+	msg.EditedAt = editedAt
+	if err := c.repo.Update(msg); err != nil {
+		logger.Errorf("Error updating the message. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.Edit.Unknown", "Error updating the message")
+	}
+	if err := c.indexMessage(msg); err != nil {
+		logger.Errorf("Error indexing the message. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.Edit.Unknown", "Error indexing the message")
+	}
+
+	// notify the connected users of the edit
+	if err := publishMessage(eventTypeEdit, msg, ""); err != nil {
+		logger.Errorf("Error publishing the edit. Message ID: %v. Error: %v", msg.Id, err)
+		return errors.InternalServerError("chat.Edit.Unknown", "Error publishing the edit")
+	}
+
+	rsp.Message = msg
+	return nil
+}
+
+// Revisions returns the previous revisions of a message
+func (c *Chat) Revisions(ctx context.Context, req *pb.RevisionsRequest, rsp *pb.RevisionsResponse) error {
+	// identify the user, they must be part of the chat the message was sent to
+	userID, err := identify(ctx, "chat.Revisions", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.MessageId) == 0 {
+		return errors.BadRequest("chat.Revisions.MissingMessageID", "MessageID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.Revisions.MissingUserID", "UserID is missing")
+	}
+
+	if _, err := c.readMessage("chat.Revisions", req.MessageId, userID); err != nil {
+		return err
+	}
+	if rsp.Revisions, err = readRevisions(req.MessageId); err != nil {
+		logger.Errorf("Error reading from the store. Message ID: %v. Error: %v", req.MessageId, err)
+		return errors.InternalServerError("chat.Revisions.Unknown", "Error reading from the store")
+	}
+	return nil
+}
+
+// readMessage loads a message and ensures the user is part of the chat it was sent to. The id passed
+// is used as the prefix of the errors returned.
+func (c *Chat) readMessage(id, messageID, userID string) (*pb.Message, error) {
+	msg, err := c.repo.Read(messageID)
+	if err == model.ErrNotFound {
+		return nil, errors.NotFound(id+".InvalidMessageID", "Message not found with this ID")
+	} else if err != nil {
+		logger.Errorf("Error reading the message. Message ID: %v. Error: %v", messageID, err)
+		return nil, errors.InternalServerError(id+".Unknown", "Error reading the message")
+	}
+	if _, err := authorize(id, msg.ChatId, userID); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+)
+
+// GetPresence returns whether users are online, and when they were last seen. If a chat is passed the
+// presence of its users in that chat is returned, a user is online if they're connected to it.
+// Otherwise the presence of the users passed who share a chat with the user is returned, a user is
+// online if they're connected to any chat.
+func (c *Chat) GetPresence(ctx context.Context, req *pb.GetPresenceRequest, rsp *pb.GetPresenceResponse) error {
+	// identify the user making the request
+	userID, err := identify(ctx, "chat.GetPresence", req.UserId)
+	if err != nil {
+		return err
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.GetPresence.MissingUserID", "UserID is missing")
+	}
+
+	// the presence of users in a chat can only be read by the users in it. The users default to all
+	// the users in the chat, and only the users in the chat are returned.
+	userIDs := req.UserIds
+	if len(req.ChatId) > 0 {
+		chat, err := authorize("chat.GetPresence", req.ChatId, userID)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			userIDs = chat.UserIds
+		}
+		var members []string
+		for _, id := range userIDs {
+			if containsString(chat.UserIds, id) {
+				members = append(members, id)
+			}
+		}
+		userIDs = members
+	} else if len(userIDs) == 0 {
+		return errors.BadRequest("chat.GetPresence.MissingUserIDs", "One or more user IDs are required")
+	} else {
+		// otherwise only the users who share a chat with the user are returned, so users can't track
+		// the presence of anyone they know the id of
+		if userIDs, err = sharingChat(userID, userIDs); err != nil {
+			logger.Errorf("Error reading from the store. User ID: %v. Error: %v", userID, err)
+			return errors.InternalServerError("chat.GetPresence.Unknown", "Error reading from the store")
+		}
+	}
+
+	now := unixMillis(time.Now())
+	for _, id := range userIDs {
+		online, err := isOnline(req.ChatId, id)
+		if err != nil {
+			logger.Errorf("Error reading presence. User ID: %v. Error: %v", id, err)
+			return errors.InternalServerError("chat.GetPresence.Unknown", "Error reading from the store")
+		}
+
+		// users which are online were last seen now
+		p := &pb.Presence{UserId: id, Online: online, LastSeenAt: now}
+		if !online {
+			if p.LastSeenAt, err = readLastSeen(id); err != nil {
+				logger.Errorf("Error reading last seen. User ID: %v. Error: %v", id, err)
+				return errors.InternalServerError("chat.GetPresence.Unknown", "Error reading from the store")
+			}
+		}
+		rsp.Presence = append(rsp.Presence, p)
+	}
+	return nil
+}
+
+// sharingChat returns the users passed who are part of at least one of the chats the user is part of,
+// along with the user themselves
+func sharingChat(userID string, userIDs []string) ([]string, error) {
+	recs, err := store.Read(memberStoreKeyPrefix+userID+"/", store.ReadPrefix())
+	if err != nil && err != store.ErrNotFound {
+		return nil, err
+	}
+
+	shared := map[string]bool{userID: true}
+	for _, rec := range recs {
+		chat, err := readChat(string(rec.Value))
+		if err == store.ErrNotFound {
+			// the chat has since been removed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, id := range chat.UserIds {
+			shared[id] = true
+		}
+	}
+
+	var result []string
+	for _, id := range userIDs {
+		if shared[id] {
+			result = append(result, id)
+		}
+	}
+	return result, nil
+}
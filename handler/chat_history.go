@@ -2,53 +2,277 @@ package handler
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 
 	pb "github.com/micro-community/micro-chat/proto"
 	"github.com/micro/micro/v3/service/errors"
-	"github.com/micro/micro/v3/service/events"
 	"github.com/micro/micro/v3/service/logger"
-	"github.com/micro/micro/v3/service/store"
+)
+
+const (
+	// defaultHistoryCount is the number of messages returned when the client doesn't specify one
+	defaultHistoryCount = 50
+	// maxHistoryCount is the upper bound of messages returned by a single history request
+	maxHistoryCount = 1000
 )
 
 // History returns the historical messages in a chat
 func (c *Chat) History(ctx context.Context, req *pb.HistoryRequest, rsp *pb.HistoryResponse) error {
-	// as per the New function, in a real world application we would authorize the request to ensure
-	// the authenticated user is part of the chat they're attempting to read the history of
+	// as per the New function, identify the user reading the history
+	userID, err := identify(ctx, "chat.History", req.UserId)
+	if err != nil {
+		return err
+	}
 
 	// validate the request
 	if len(req.ChatId) == 0 {
 		return errors.BadRequest("chat.History.MissingChatID", "ChatID is missing")
 	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.History.MissingUserID", "UserID is missing")
+	}
+	if req.ByTime {
+		if req.FromTimestamp < 0 || req.ToTimestamp < 0 {
+			return errors.BadRequest("chat.History.InvalidTimestamp", "Timestamps cannot be negative")
+		}
+		if req.ToTimestamp > 0 && req.FromTimestamp > req.ToTimestamp {
+			return errors.BadRequest("chat.History.InvalidTimeRange", "FromTimestamp must not be after ToTimestamp")
+		}
+	}
+	if req.RecentCount < 0 {
+		return errors.BadRequest("chat.History.InvalidRecentCount", "RecentCount cannot be negative")
+	}
+	var cursor *historyCursor
+	if len(req.PageToken) > 0 {
+		if cursor, err = decodeHistoryCursor(req.PageToken); err != nil {
+			return errors.BadRequest("chat.History.InvalidPageToken", "PageToken is invalid")
+		}
+	}
 
-	// lookup the chat from the store to ensure it's valid
-	if _, err := store.Read(chatStoreKeyPrefix + req.ChatId); err == store.ErrNotFound {
-		return errors.BadRequest("chat.History.InvalidChatID", "Chat not found with this ID")
-	} else if err != nil {
-		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", req.ChatId, err)
-		return errors.InternalServerError("chat.History.Unknown", "Error reading from the store")
+	// lookup the chat from the store to ensure it's valid and authorize the request to ensure the
+	// user is part of the chat they're attempting to read the history of
+	if _, err := authorize("chat.History", req.ChatId, userID); err != nil {
+		return err
 	}
 
-	// lookup the historical messages for the chat using the event store. lots of packages in micro
-	// support options, in this case we'll pass the ReadLimit option to restrict the number of messages
-	// we'll load from the events store.
-	messages, err := events.Read(chatEventKeyPrefix+req.ChatId, events.ReadLimit(50))
+	// lookup the historical messages for the chat from the messages table, where they're ordered by
+	// the time they were sent. Offsets would drift as new messages arrive, so the pages are selected
+	// using cursors anchored on the time a message was sent and its id, which gives every message a
+	// stable position. The positions of the page are found by searching the ordered index, so only the
+	// messages compared and the messages in the page are read rather than the whole chat.
+	at := c.messageAt(req.ChatId)
+
+	// when querying by time only the messages sent within the time range are returned, [lo, hi) are
+	// the positions of the messages which can be returned
+	lo, hi, err := c.historyRange(at, req)
 	if err != nil {
-		logger.Errorf("Error reading from the event store. Chat ID: %v. Error: %v", req.ChatId, err)
-		return errors.InternalServerError("chat.History.Unknown", "Error reading from the event store")
+		logger.Errorf("Error reading from the messages table. Chat ID: %v. Error: %v", req.ChatId, err)
+		return errors.InternalServerError("chat.History.Unknown", "Error reading from the messages table")
+	}
+
+	// determine the size of the page. By default we return the most recent messages, or the first
+	// messages within the time range.
+	size := int(req.RecentCount)
+	if size == 0 && req.ByTime {
+		size = maxHistoryCount
+	} else if size == 0 {
+		size = defaultHistoryCount
+	} else if size > maxHistoryCount {
+		size = maxHistoryCount
+	}
+
+	// find the position of the message the cursor is anchored on, the position of the first message
+	// after it for a newer page or the position of the message itself for an older page
+	var anchor int
+	if cursor != nil {
+		anchor, err = searchPosition(at, func(msg *pb.Message) bool {
+			if cursor.Older {
+				return !positionBefore(msg.SentAt, msg.Id, cursor.Timestamp, cursor.MessageID)
+			}
+			return positionBefore(cursor.Timestamp, cursor.MessageID, msg.SentAt, msg.Id)
+		})
+		if err != nil {
+			logger.Errorf("Error reading from the messages table. Chat ID: %v. Error: %v", req.ChatId, err)
+			return errors.InternalServerError("chat.History.Unknown", "Error reading from the messages table")
+		}
 	}
 
-	// we've loaded the messages from the event store. next we need to serialize them and return them
-	// to the client. The message is stored in the event payload, to retrieve it we need to unmarshal
-	// the event into a message struct.
-	rsp.Messages = make([]*pb.Message, len(messages))
-	for i, ev := range messages {
-		var msg pb.Message
-		if err := ev.Unmarshal(&msg); err != nil {
-			logger.Errorf("Error unmarshaling event: %v", err)
-			return errors.InternalServerError("chat.History.Unknown", "Error unmarshaling event")
+	// select the page of messages, [start, end) are the positions of the messages returned
+	start, end := pageBounds(lo, hi, size, cursor, anchor, req.ByTime)
+	messages := []*pb.Message{}
+	if end > start {
+		if messages, err = c.repo.ListByChat(req.ChatId, int64(end-start), int64(start)); err != nil {
+			logger.Errorf("Error reading from the messages table. Chat ID: %v. Error: %v", req.ChatId, err)
+			return errors.InternalServerError("chat.History.Unknown", "Error reading from the messages table")
 		}
-		rsp.Messages[i] = &msg
+	}
+	rsp.Messages = messages
+
+	// generate the cursors for the pages either side of this one. There's always a newer page to
+	// poll for if the client already has a cursor for it, even when no new messages were found.
+	if start > lo && len(messages) > 0 {
+		rsp.OlderPageToken = newHistoryCursor(messages[0], true).encode()
+	}
+	if len(messages) > 0 {
+		rsp.NewerPageToken = newHistoryCursor(messages[len(messages)-1], false).encode()
+	} else if cursor != nil && !cursor.Older {
+		rsp.NewerPageToken = req.PageToken
 	}
 
 	return nil
 }
+
+// historyRange returns the positions [lo, hi) of the messages in the chat which can be returned by the
+// request, which is every message unless the request queries by time
+func (c *Chat) historyRange(at func(int) (*pb.Message, error), req *pb.HistoryRequest) (int, int, error) {
+	// the position after the last message is the number of messages in the chat
+	hi, err := searchPosition(at, func(*pb.Message) bool { return false })
+	if err != nil || !req.ByTime {
+		return 0, hi, err
+	}
+
+	lo, err := searchPosition(at, func(msg *pb.Message) bool { return msg.SentAt >= req.FromTimestamp })
+	if err != nil || req.ToTimestamp == 0 {
+		return lo, hi, err
+	}
+	hi, err = searchPosition(at, func(msg *pb.Message) bool { return msg.SentAt > req.ToTimestamp })
+	return lo, hi, err
+}
+
+// pageBounds returns the positions [start, end) of the page of at most size messages selected from the
+// messages at positions [lo, hi). Without a cursor the most recent messages are selected, or the first
+// messages when querying by time. With a cursor, anchor is the position of the message the cursor is
+// anchored on for an older page, which ends right before it, or the position of the first message
+// after it for a newer page, which starts there.
+func pageBounds(lo, hi, size int, cursor *historyCursor, anchor int, byTime bool) (int, int) {
+	switch {
+	case cursor != nil && cursor.Older:
+		end := min(hi, max(lo, anchor))
+		return max(lo, end-size), end
+	case cursor != nil:
+		start := min(hi, max(lo, anchor))
+		return start, min(hi, start+size)
+	case byTime:
+		return lo, min(hi, lo+size)
+	default:
+		return max(lo, hi-size), hi
+	}
+}
+
+// messageAt returns a function which reads the message at a position in the chat, or nil if the chat
+// has fewer messages
+func (c *Chat) messageAt(chatID string) func(int) (*pb.Message, error) {
+	return func(i int) (*pb.Message, error) {
+		msgs, err := c.repo.ListByChat(chatID, 1, int64(i))
+		if err != nil || len(msgs) == 0 {
+			return nil, err
+		}
+		return msgs[0], nil
+	}
+}
+
+// lastMessage returns the last message sent to the chat, or nil if it has none
+func (c *Chat) lastMessage(chatID string) (*pb.Message, error) {
+	at := c.messageAt(chatID)
+	count, err := searchPosition(at, func(*pb.Message) bool { return false })
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	return at(count - 1)
+}
+
+// searchPosition returns the position of the first message for which f is true, or the number of
+// messages if there is none. Like sort.Search, f must be false for the messages before the position
+// and true from it onwards. The position is found by probing positions at doubling intervals and then
+// binary searching between the last two probes, so only O(log n) messages are read. at returns the
+// message at a position, or nil past the last message.
+func searchPosition(at func(int) (*pb.Message, error), f func(*pb.Message) bool) (int, error) {
+	found := func(i int) (bool, error) {
+		msg, err := at(i)
+		if err != nil {
+			return false, err
+		}
+		return msg == nil || f(msg), nil
+	}
+
+	// f is false before lo, and true at hi
+	lo, hi := 0, 0
+	for step := 1; ; step *= 2 {
+		ok, err := found(hi)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			break
+		}
+		lo, hi = hi+1, hi+step
+	}
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		ok, err := found(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return lo, nil
+}
+
+// historyCursor is the decoded form of a page token. It's anchored on the position of a message
+// rather than an offset, so messages sent whilst the client is paging don't shift the pages.
+type historyCursor struct {
+	Older     bool   `json:"o,omitempty"`
+	Timestamp int64  `json:"t"`
+	MessageID string `json:"m"`
+}
+
+// newHistoryCursor returns a cursor anchored on the message
+func newHistoryCursor(msg *pb.Message, older bool) *historyCursor {
+	return &historyCursor{Older: older, Timestamp: msg.SentAt, MessageID: msg.Id}
+}
+
+// positionBefore returns true if the message at position a is ordered before the one at position b
+func positionBefore(aTs int64, aID string, bTs int64, bID string) bool {
+	if aTs != bTs {
+		return aTs < bTs
+	}
+	return aID < bID
+}
+
+// encode the cursor into an opaque page token
+func (c *historyCursor) encode() string {
+	bytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// decodeHistoryCursor decodes a page token generated by historyCursor.encode
+func decodeHistoryCursor(token string) (*historyCursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var c historyCursor
+	if err := json.Unmarshal(bytes, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	pb "github.com/micro-community/micro-chat/proto"
+)
+
+func TestPositionBefore(t *testing.T) {
+	tests := []struct {
+		name   string
+		aTs    int64
+		aID    string
+		bTs    int64
+		bID    string
+		before bool
+	}{
+		{name: "earlier", aTs: 1, aID: "b", bTs: 2, bID: "a", before: true},
+		{name: "later", aTs: 2, aID: "a", bTs: 1, bID: "b", before: false},
+		{name: "same time, lower id", aTs: 1, aID: "a", bTs: 1, bID: "b", before: true},
+		{name: "same time, higher id", aTs: 1, aID: "b", bTs: 1, bID: "a", before: false},
+		{name: "same message", aTs: 1, aID: "a", bTs: 1, bID: "a", before: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := positionBefore(tt.aTs, tt.aID, tt.bTs, tt.bID); got != tt.before {
+				t.Errorf("positionBefore() = %v, want %v", got, tt.before)
+			}
+		})
+	}
+}
+
+func TestHistoryCursor(t *testing.T) {
+	cursor := newHistoryCursor(&pb.Message{Id: "m1", SentAt: 1603000000000}, true)
+	decoded, err := decodeHistoryCursor(cursor.encode())
+	if err != nil {
+		t.Fatalf("decodeHistoryCursor() error = %v", err)
+	}
+	if *decoded != *cursor {
+		t.Errorf("decodeHistoryCursor() = %+v, want %+v", decoded, cursor)
+	}
+
+	for _, token := range []string{"not base64!", "bm90IGpzb24"} {
+		if _, err := decodeHistoryCursor(token); err == nil {
+			t.Errorf("decodeHistoryCursor(%q) expected an error", token)
+		}
+	}
+}
+
+// messages returns n messages sent a millisecond apart, with two messages sent at each time so the
+// ids determine their order
+func messages(n int) []*pb.Message {
+	msgs := make([]*pb.Message, n)
+	for i := range msgs {
+		msgs[i] = &pb.Message{Id: fmt.Sprintf("m%03d", i), SentAt: int64(100 + i/2)}
+	}
+	return msgs
+}
+
+// messageAtSlice returns a function reading the messages from the slice, and the number of reads made
+func messageAtSlice(msgs []*pb.Message) (func(int) (*pb.Message, error), *int) {
+	reads := 0
+	return func(i int) (*pb.Message, error) {
+		reads++
+		if i >= len(msgs) {
+			return nil, nil
+		}
+		return msgs[i], nil
+	}, &reads
+}
+
+func TestSearchPosition(t *testing.T) {
+	msgs := messages(100)
+	tests := []struct {
+		name string
+		n    int
+		f    func(*pb.Message) bool
+		want int
+	}{
+		{name: "empty chat", n: 0, f: func(*pb.Message) bool { return true }, want: 0},
+		{name: "count", n: 100, f: func(*pb.Message) bool { return false }, want: 100},
+		{name: "first", n: 100, f: func(*pb.Message) bool { return true }, want: 0},
+		{name: "single message", n: 1, f: func(*pb.Message) bool { return false }, want: 1},
+		{name: "by time", n: 100, f: func(m *pb.Message) bool { return m.SentAt >= 120 }, want: 40},
+		{name: "after time", n: 100, f: func(m *pb.Message) bool { return m.SentAt > 120 }, want: 42},
+		{name: "after message", n: 100, f: func(m *pb.Message) bool {
+			return positionBefore(120, "m040", m.SentAt, m.Id)
+		}, want: 41},
+		{name: "at or after message", n: 100, f: func(m *pb.Message) bool {
+			return !positionBefore(m.SentAt, m.Id, 120, "m041")
+		}, want: 41},
+		{name: "last", n: 100, f: func(m *pb.Message) bool { return m.Id == "m099" }, want: 99},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, reads := messageAtSlice(msgs[:tt.n])
+			got, err := searchPosition(at, tt.f)
+			if err != nil {
+				t.Fatalf("searchPosition() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("searchPosition() = %v, want %v", got, tt.want)
+			}
+			if *reads > 16 {
+				t.Errorf("searchPosition() read %v messages, expected at most 16", *reads)
+			}
+		})
+	}
+}
+
+func TestSearchPositionError(t *testing.T) {
+	want := errors.New("store unavailable")
+	at := func(int) (*pb.Message, error) { return nil, want }
+	if _, err := searchPosition(at, func(*pb.Message) bool { return true }); err != want {
+		t.Errorf("searchPosition() error = %v, want %v", err, want)
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	older := &historyCursor{Older: true}
+	newer := &historyCursor{}
+	tests := []struct {
+		name       string
+		lo, hi     int
+		size       int
+		cursor     *historyCursor
+		anchor     int
+		byTime     bool
+		start, end int
+	}{
+		{name: "most recent", lo: 0, hi: 100, size: 10, start: 90, end: 100},
+		{name: "most recent, fewer than size", lo: 0, hi: 5, size: 10, start: 0, end: 5},
+		{name: "empty chat", lo: 0, hi: 0, size: 10, start: 0, end: 0},
+		{name: "by time", lo: 20, hi: 80, size: 10, byTime: true, start: 20, end: 30},
+		{name: "by time, fewer than size", lo: 20, hi: 25, size: 10, byTime: true, start: 20, end: 25},
+		{name: "older page", lo: 0, hi: 100, size: 10, cursor: older, anchor: 50, start: 40, end: 50},
+		{name: "older page, first page", lo: 0, hi: 100, size: 10, cursor: older, anchor: 5, start: 0, end: 5},
+		{name: "older page, no older messages", lo: 0, hi: 100, size: 10, cursor: older, anchor: 0, start: 0, end: 0},
+		{name: "older page, within time range", lo: 20, hi: 80, size: 10, cursor: older, anchor: 25, byTime: true, start: 20, end: 25},
+		{name: "older page, anchor after time range", lo: 20, hi: 80, size: 10, cursor: older, anchor: 95, byTime: true, start: 70, end: 80},
+		{name: "newer page", lo: 0, hi: 100, size: 10, cursor: newer, anchor: 50, start: 50, end: 60},
+		{name: "newer page, last page", lo: 0, hi: 100, size: 10, cursor: newer, anchor: 95, start: 95, end: 100},
+		{name: "newer page, no newer messages", lo: 0, hi: 100, size: 10, cursor: newer, anchor: 100, start: 100, end: 100},
+		{name: "newer page, anchor before time range", lo: 20, hi: 80, size: 10, cursor: newer, anchor: 5, byTime: true, start: 20, end: 30},
+		{name: "newer page, anchor after time range", lo: 20, hi: 80, size: 10, cursor: newer, anchor: 95, byTime: true, start: 80, end: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := pageBounds(tt.lo, tt.hi, tt.size, tt.cursor, tt.anchor, tt.byTime)
+			if start != tt.start || end != tt.end {
+				t.Errorf("pageBounds() = [%v, %v), want [%v, %v)", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+)
+
+// ListByUsers returns all the chats between a set of users, including the ones created using forceNew
+func (c *Chat) ListByUsers(ctx context.Context, req *pb.ListByUsersRequest, rsp *pb.ListByUsersResponse) error {
+	// validate the request
+	if len(req.UserIds) == 0 {
+		return errors.BadRequest("chat.ListByUsers.MissingUserIDs", "One or more user IDs are required")
+	}
+
+	// users can only list the chats they're part of
+	userID, err := identify(ctx, "chat.ListByUsers", req.UserId)
+	if err != nil {
+		return err
+	}
+	if !containsString(req.UserIds, userID) {
+		return errors.Forbidden("chat.ListByUsers.Forbidden", "Not one of the users")
+	}
+	usersKey := participantsKey(req.UserIds)
+
+	// lookup the default chat, there won't be one if no chats have been created for the users yet
+	if recs, err := store.Read(chatStoreKeyPrefix + usersKey); err == nil {
+		rsp.DefaultChatId = string(recs[0].Value)
+	} else if err != store.ErrNotFound {
+		logger.Errorf("Error reading from the store. Key: %v. Error: %v", usersKey, err)
+		return errors.InternalServerError("chat.ListByUsers.Unknown", "Error reading from the store")
+	}
+
+	// lookup all the chats using the participants index. The prefix option is used to read all the
+	// records starting with the key.
+	recs, err := store.Read(participantStoreKeyPrefix+usersKey+"/", store.ReadPrefix())
+	if err != nil && err != store.ErrNotFound {
+		logger.Errorf("Error reading from the store. Key: %v. Error: %v", usersKey, err)
+		return errors.InternalServerError("chat.ListByUsers.Unknown", "Error reading from the store")
+	}
+	rsp.ChatIds = make([]string, len(recs))
+	for i, rec := range recs {
+		rsp.ChatIds[i] = string(rec.Value)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"sort"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+)
+
+// ListChats returns all the chats a user is part of, the most recently active chat first, along with
+// the number of messages the user hasn't read in each. Chats the user has removed aren't returned.
+func (c *Chat) ListChats(ctx context.Context, req *pb.ListChatsRequest, rsp *pb.ListChatsResponse) error {
+	// users can only list their own chats
+	userID, err := identify(ctx, "chat.ListChats", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.ListChats.MissingUserID", "UserID is missing")
+	}
+
+	// lookup the chats the user is part of using the members index
+	recs, err := store.Read(memberStoreKeyPrefix+userID+"/", store.ReadPrefix())
+	if err != nil && err != store.ErrNotFound {
+		logger.Errorf("Error reading from the store. User ID: %v. Error: %v", userID, err)
+		return errors.InternalServerError("chat.ListChats.Unknown", "Error reading from the store")
+	}
+
+	// load each of the chats along with the time they were last active and the unread messages
+	rsp.Chats = make([]*pb.ChatInfo, 0, len(recs))
+	for _, rec := range recs {
+		chatID := string(rec.Value)
+
+		// skip the chats the user has removed
+		if hidden, err := isHidden(chatID, userID); err != nil {
+			logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
+			return errors.InternalServerError("chat.ListChats.Unknown", "Error reading from the store")
+		} else if hidden {
+			continue
+		}
+
+		chat, err := readChat(chatID)
+		if err == store.ErrNotFound {
+			// the chat has since been removed
+			continue
+		} else if err != nil {
+			logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
+			return errors.InternalServerError("chat.ListChats.Unknown", "Error reading from the store")
+		}
+
+		if chat.LastActivityAt, err = readLastActivity(chatID); err != nil {
+			logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
+			return errors.InternalServerError("chat.ListChats.Unknown", "Error reading from the store")
+		}
+		if chat.UnreadCount, err = c.unreadCount(chatID, userID); err != nil {
+			logger.Errorf("Error counting unread messages. Chat ID: %v. Error: %v", chatID, err)
+			return errors.InternalServerError("chat.ListChats.Unknown", "Error reading from the store")
+		}
+		rsp.Chats = append(rsp.Chats, chat)
+	}
+
+	// order the chats by the time they were last active, chats without any messages are ordered by
+	// the time they were created
+	sort.SliceStable(rsp.Chats, func(i, j int) bool {
+		return lastActive(rsp.Chats[i]) > lastActive(rsp.Chats[j])
+	})
+
+	return nil
+}
+
+// lastActive returns the time the chat was last active
+func lastActive(chat *pb.ChatInfo) int64 {
+	if chat.LastActivityAt > 0 {
+		return chat.LastActivityAt
+	}
+	return chat.CreatedAt
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/micro-community/micro-chat/model"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// MarkRead records the user has read the chat up to a message. The position only moves forward, so
+// marking an older message read has no effect. The other users connected to the chat receive a read
+// receipt.
+func (c *Chat) MarkRead(ctx context.Context, req *pb.MarkReadRequest, rsp *pb.MarkReadResponse) error {
+	// users can only mark chats read for themselves
+	userID, err := identify(ctx, "chat.MarkRead", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.MarkRead.MissingChatID", "ChatID is missing")
+	}
+	if len(req.MessageId) == 0 {
+		return errors.BadRequest("chat.MarkRead.MissingMessageID", "MessageID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.MarkRead.MissingUserID", "UserID is missing")
+	}
+
+	// ensure the user is part of the chat
+	if _, err := authorize("chat.MarkRead", req.ChatId, userID); err != nil {
+		return err
+	}
+
+	rsp.Receipt, err = c.markRead("chat.MarkRead", req.ChatId, userID, req.MessageId)
+	return err
+}
+
+// markRead moves the position the user has read the chat up to forward to the message, and publishes
+// the receipt. The receipt of the position the user has read up to is returned. The id passed is
+// used as the prefix of the errors returned.
+func (c *Chat) markRead(id, chatID, userID, messageID string) (*pb.ReadReceipt, error) {
+	// the message must be in the chat
+	msg, err := c.repo.Read(messageID)
+	if err == model.ErrNotFound || (err == nil && msg.ChatId != chatID) {
+		return nil, errors.BadRequest(id+".InvalidMessageID", "Message not found with this ID")
+	} else if err != nil {
+		logger.Errorf("Error reading message. Message ID: %v. Error: %v", messageID, err)
+		return nil, errors.InternalServerError(id+".Unknown", "Error reading the message")
+	}
+
+	// the position is read and then written, so updates from the users other devices are serialised
+	unlock, err := c.lock(id, readStoreKeyPrefix+chatID+"/"+userID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	cursor, err := readReadCursor(chatID, userID)
+	if err != nil {
+		logger.Errorf("Error reading from the store. Chat ID: %v. User ID: %v. Error: %v", chatID, userID, err)
+		return nil, errors.InternalServerError(id+".Unknown", "Error reading from the store")
+	}
+
+	// the user has already read past this message
+	if cursor != nil && !positionBefore(cursor.SentAt, cursor.MessageID, msg.SentAt, msg.Id) {
+		return &pb.ReadReceipt{UserId: userID, MessageId: cursor.MessageID, ReadAt: cursor.ReadAt}, nil
+	}
+
+	cursor = &readCursor{MessageID: msg.Id, SentAt: msg.SentAt, ReadAt: unixMillis(time.Now())}
+	if err := writeReadCursor(chatID, userID, cursor); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chatID, userID, err)
+		return nil, errors.InternalServerError(id+".Unknown", "Error writing to the store")
+	}
+
+	// let the users connected to the chat know, including the users other devices so they can clear
+	// their unread messages. Receipts are best effort, the position can always be read from the store.
+	receipt := &pb.ReadReceipt{UserId: userID, MessageId: cursor.MessageID, ReadAt: cursor.ReadAt}
+	ev := &pb.ChatEvent{ChatId: chatID, Event: &pb.ChatEvent_ReadReceipt{ReadReceipt: receipt}}
+	if err := publishSignal(ev); err != nil {
+		logger.Errorf("Error publishing read receipt. Chat ID: %v. Error: %v", chatID, err)
+	}
+	return receipt, nil
+}
+
+// unreadCount returns the number of messages sent to the chat by the other users after the position
+// the user has read the chat up to. Deleted messages aren't counted. Counting stops at
+// maxUnreadCount, so chats with a long unread history don't have to be read in full.
+func (c *Chat) unreadCount(chatID, userID string) (int64, error) {
+	cursor, err := readReadCursor(chatID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// only the messages after the cursor are read, starting from the position of the first of them in
+	// the ordered index. Users are given a cursor when they join a chat, so without one they've been in
+	// the chat since before its first message.
+	var after int
+	if cursor != nil {
+		after, err = searchPosition(c.messageAt(chatID), func(msg *pb.Message) bool {
+			return positionBefore(cursor.SentAt, cursor.MessageID, msg.SentAt, msg.Id)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var count int64
+	for offset := int64(after); ; offset += maxUnreadCount {
+		msgs, err := c.repo.ListByChat(chatID, maxUnreadCount, offset)
+		if err != nil {
+			return 0, err
+		}
+		for _, msg := range msgs {
+			if msg.UserId == userID || msg.Deleted {
+				continue
+			}
+			if count++; count == maxUnreadCount {
+				return count, nil
+			}
+		}
+		if len(msgs) < maxUnreadCount {
+			return count, nil
+		}
+	}
+}
+
+// seedReadCursor records the user as having read the chat up to its last message, unless they've
+// read some of it already. It's called when a user joins a chat, so the messages sent before they
+// joined aren't unread. Chats without messages are left without a cursor.
+func (c *Chat) seedReadCursor(chatID, userID string) error {
+	unlock, err := c.acquireLock(readStoreKeyPrefix + chatID + "/" + userID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if cursor, err := readReadCursor(chatID, userID); err != nil || cursor != nil {
+		return err
+	}
+
+	last, err := c.lastMessage(chatID)
+	if err != nil || last == nil {
+		return err
+	}
+	cursor := &readCursor{MessageID: last.Id, SentAt: last.SentAt, ReadAt: unixMillis(time.Now())}
+	return writeReadCursor(chatID, userID, cursor)
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// AddMembers adds users to a chat, any user in the chat other than read only users can add other
+// users. A system message is recorded in the history of the chat and the users connected to the chat
+// are notified.
+func (c *Chat) AddMembers(ctx context.Context, req *pb.AddMembersRequest, rsp *pb.AddMembersResponse) error {
+	// identify the user adding the users
+	userID, err := identify(ctx, "chat.AddMembers", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.AddMembers.MissingChatID", "ChatID is missing")
+	}
+	if len(req.UserIds) == 0 {
+		return errors.BadRequest("chat.AddMembers.MissingUserIDs", "One or more user IDs are required")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.AddMembers.MissingUserID", "UserID is missing")
+	}
+
+	unlock, err := c.lock("chat.AddMembers", chatStoreKeyPrefix+req.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := authorize("chat.AddMembers", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	if roleOf(chat, userID) == pb.Role_READ_ONLY {
+		return errors.Forbidden("chat.AddMembers.ReadOnly", "Read only users can't add users")
+	}
+
+	// the users already in the chat are ignored
+	var added []string
+	for _, id := range req.UserIds {
+		if len(id) > 0 && !containsString(chat.UserIds, id) && !containsString(added, id) {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		rsp.Chat = chat
+		return nil
+	}
+
+	users := append(append([]string{}, chat.UserIds...), added...)
+	if err := c.setMembers("chat.AddMembers", chat, users); err != nil {
+		return err
+	}
+	c.announceMembers(chat.Id, userID, added, true, fmt.Sprintf("%v added %v", userID, strings.Join(added, ", ")))
+
+	rsp.Chat = chat
+	return nil
+}
+
+// RemoveMembers removes users from a chat, only owners and admins can remove other users and admins
+// can't remove owners or other admins. A system message is recorded in the history of the chat and
+// the users connected to the chat are notified.
+func (c *Chat) RemoveMembers(ctx context.Context, req *pb.RemoveMembersRequest, rsp *pb.RemoveMembersResponse) error {
+	// identify the user removing the users
+	userID, err := identify(ctx, "chat.RemoveMembers", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.RemoveMembers.MissingChatID", "ChatID is missing")
+	}
+	if len(req.UserIds) == 0 {
+		return errors.BadRequest("chat.RemoveMembers.MissingUserIDs", "One or more user IDs are required")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.RemoveMembers.MissingUserID", "UserID is missing")
+	}
+
+	unlock, err := c.lock("chat.RemoveMembers", chatStoreKeyPrefix+req.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := authorize("chat.RemoveMembers", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	role := roleOf(chat, userID)
+	if !canManage(chat, userID) {
+		return errors.Forbidden("chat.RemoveMembers.Forbidden", "Only an owner or admin can remove users")
+	}
+
+	// users leave a chat using Leave, and the users not in the chat are ignored
+	var users, removed []string
+	for _, id := range chat.UserIds {
+		if id != userID && containsString(req.UserIds, id) {
+			if role != pb.Role_OWNER && rank(roleOf(chat, id)) >= rank(role) {
+				return errors.Forbidden("chat.RemoveMembers.Forbidden", "Admins can't remove owners or other admins")
+			}
+			removed = append(removed, id)
+		} else {
+			users = append(users, id)
+		}
+	}
+	if len(removed) == 0 {
+		rsp.Chat = chat
+		return nil
+	}
+
+	if err := c.setMembers("chat.RemoveMembers", chat, users); err != nil {
+		return err
+	}
+	c.announceMembers(chat.Id, userID, removed, false, fmt.Sprintf("%v removed %v", userID, strings.Join(removed, ", ")))
+
+	rsp.Chat = chat
+	return nil
+}
+
+// Leave a chat. The other users in the chat are notified, and if the user was the last user in the
+// chat it's destroyed. If the user was the last owner of the chat, the highest ranked user left becomes
+// the owner.
+func (c *Chat) Leave(ctx context.Context, req *pb.LeaveRequest, rsp *pb.LeaveResponse) error {
+	// users can only leave chats themselves
+	userID, err := identify(ctx, "chat.Leave", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.Leave.MissingChatID", "ChatID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.Leave.MissingUserID", "UserID is missing")
+	}
+
+	unlock, err := c.lock("chat.Leave", chatStoreKeyPrefix+req.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := authorize("chat.Leave", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+
+	// nobody is left to read the chat, so it's destroyed
+	if len(chat.UserIds) == 1 {
+		if err := c.destroyChat(chat); err != nil {
+			logger.Errorf("Error destroying chat. Chat ID: %v. Error: %v", chat.Id, err)
+			return errors.InternalServerError("chat.Leave.Unknown", "Error destroying the chat")
+		}
+		return nil
+	}
+
+	text := fmt.Sprintf("%v left", userID)
+	if roleOf(chat, userID) == pb.Role_OWNER && len(owners(chat)) <= 1 {
+		next := successor(chat, userID)
+		setRole(chat, next, pb.Role_OWNER)
+		text = fmt.Sprintf("%v left, %v is now the owner", userID, next)
+	}
+
+	var users []string
+	for _, id := range chat.UserIds {
+		if id != userID {
+			users = append(users, id)
+		}
+	}
+	if err := c.setMembers("chat.Leave", chat, users); err != nil {
+		return err
+	}
+	c.announceMembers(chat.Id, userID, []string{userID}, false, text)
+	return nil
+}
+
+// setMembers replaces the users of the chat, keeping the indexes of the chat consistent. The chat is
+// indexed by its users, so it's moved from the index of the previous users to the index of the new
+// users. If it was the default chat for the previous users it no longer is, since the users have
+// changed, and it only becomes the default for the new users if they didn't already have one. Public
+// rooms aren't indexed by their users. The id passed is used as the prefix of the errors returned.
+func (c *Chat) setMembers(id string, chat *pb.ChatInfo, users []string) error {
+	if !chat.Public {
+		if err := unindexParticipants(chat); err != nil {
+			logger.Errorf("Error deleting from the store. Chat ID: %v. Error: %v", chat.Id, err)
+			return errors.InternalServerError(id+".Unknown", "Error deleting from the store")
+		}
+	}
+	for _, userID := range chat.UserIds {
+		if containsString(users, userID) {
+			continue
+		}
+		if err := removeMember(chat.Id, userID); err != nil {
+			logger.Errorf("Error deleting from the store. Chat ID: %v. User ID: %v. Error: %v", chat.Id, userID, err)
+			return errors.InternalServerError(id+".Unknown", "Error deleting from the store")
+		}
+	}
+
+	// the roles of the users removed are forgotten, so they're members if they're added again
+	for _, userID := range chat.UserIds {
+		if !containsString(users, userID) {
+			delete(chat.Roles, userID)
+		}
+	}
+	// the users joining have read the messages sent before they joined, so they aren't unread
+	previous := chat.UserIds
+	chat.UserIds = users
+	for _, userID := range chat.UserIds {
+		if err := addMember(chat.Id, userID); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chat.Id, userID, err)
+			return errors.InternalServerError(id+".Unknown", "Error writing to the store")
+		}
+		if containsString(previous, userID) {
+			continue
+		}
+		if err := c.seedReadCursor(chat.Id, userID); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chat.Id, userID, err)
+			return errors.InternalServerError(id+".Unknown", "Error writing to the store")
+		}
+	}
+	if !chat.Public {
+		if err := indexParticipants(chat); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+			return errors.InternalServerError(id+".Unknown", "Error writing to the store")
+		}
+	}
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError(id+".Unknown", "Error writing to the store")
+	}
+	return nil
+}
+
+// announceMembers records a change to the users of a chat in its history as a system message, and
+// notifies the users connected to the chat. The change has already been made, so errors are only
+// logged.
+func (c *Chat) announceMembers(chatID, actorID string, userIDs []string, joined bool, text string) {
+	c.announce(chatID, actorID, text)
+
+	ev := &pb.ChatEvent{ChatId: chatID}
+	member := &pb.MemberEvent{UserIds: userIDs, ActorId: actorID}
+	if joined {
+		ev.Event = &pb.ChatEvent_MemberJoined{MemberJoined: member}
+	} else {
+		ev.Event = &pb.ChatEvent_MemberLeft{MemberLeft: member}
+	}
+	if err := publishEvent(ev); err != nil {
+		logger.Errorf("Error publishing event. Chat ID: %v. Error: %v", chatID, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	pb "github.com/micro-community/micro-chat/proto"
@@ -14,11 +15,14 @@ import (
 
 // New 创建一个支持幂等操作的chat对象
 func (c *Chat) New(ctx context.Context, req *pb.NewRequest, rsp *pb.NewResponse) error {
-	// in a real world application we would authorize the request to ensure the authenticated user
-	// is part of the chat they're attempting to create. We could do this by getting the user id from
-	// auth.AccountFromContext(ctx) and then validating the presence of their id in req.UserIds. If
-	// the user is not part of the request then we'd return a Forbidden error, which the micro api
-	// would transform to a 403 status code.
+	// authorize the request to ensure the authenticated user is part of the chat they're attempting
+	// to create. We do this by getting the user id from auth.AccountFromContext(ctx) and then
+	// validating the presence of their id in req.UserIds. If the user is not part of the request then
+	// we return a Forbidden error, which the micro api will transform to a 403 status code.
+	creatorID, err := identify(ctx, "chat.New", req.CreatorId)
+	if err != nil {
+		return err
+	}
 
 	// validate the request
 	if len(req.UserIds) == 0 {
@@ -28,28 +32,64 @@ func (c *Chat) New(ctx context.Context, req *pb.NewRequest, rsp *pb.NewResponse)
 		// 500 (InternalServerError) and 408 (Timeout) errors are retried.
 		return errors.BadRequest("chat.New.MissingUserIDs", "One or more user IDs are required")
 	}
+	if len(creatorID) == 0 {
+		return errors.BadRequest("chat.New.MissingCreatorID", "CreatorID is missing")
+	}
+	if !containsString(req.UserIds, creatorID) {
+		return errors.Forbidden("chat.New.Forbidden", "The creator must be one of the users")
+	}
 
 	// construct a key to identify the chat, we'll do this by sorting the user ids alphabetically and
 	// then joining them. When a service calls the store, the data returned will be automatically scoped
 	// to the service however it's still advised to use a prefix when writing data since this allows
-	// other types of keys to be written in the future. We'll make a copy of the req.UserIds object as
-	// it's a good practice to not mutate the request object.
-	sortedIDs := make([]string, len(req.UserIds))
-	copy(sortedIDs, req.UserIds)
-	sort.Strings(sortedIDs)
+	// other types of keys to be written in the future.
+	usersKey := participantsKey(req.UserIds)
 
 	// key to lookup the chat in the store using, e.g. "chat/usera-userb-userc"
-	key := chatStoreKeyPrefix + strings.Join(sortedIDs, "-")
+	key := chatStoreKeyPrefix + usersKey
 
-	// read from the store to check if a chat with these users already exists
+	// calls for the same users are serialised, otherwise concurrent calls could each find no default
+	// chat and create one. The default is read once locked, so a call waiting on the lock returns the
+	// chat created by the call holding it.
+	unlock, err := c.lock("chat.New", key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// read from the store to check if a chat with these users already exists. The client can force
+	// a new chat to be created, however we still need to know if there is a default chat for the
+	// users since the first chat created becomes the default.
 	recs, err := store.Read(key)
+	var existing *pb.ChatInfo
 	if err == nil {
 		// if an error wasn't returned, at least one record was found. The value returned by the store
-		// is the bytes representation of the chat id. We'll convert this back into a string and return
-		// it to the client.
-		rsp.ChatId = string(recs[0].Value)
+		// is the bytes representation of the chat id. The chat could've since been destroyed, in which
+		// case it's no longer the default and a new chat is created.
+		if existing, err = readChat(string(recs[0].Value)); err != nil && err != store.ErrNotFound {
+			logger.Errorf("Error reading from the store. Key: %v. Error: %v", key, err)
+			return errors.InternalServerError("chat.New.Unknown", "Error reading from the store")
+		}
+	}
+	if err == nil && len(existing.UserIds) == 0 {
+		// chats created before their users were recorded only stored their id, so nobody could be
+		// authorized to use them. The default chat is keyed by its users, so they're the users in the
+		// request and are recorded now.
+		if err := c.backfillUsers(existing.Id, creatorID, req.UserIds); err != nil {
+			return err
+		}
+	}
+	if err == nil && !req.ForceNew {
+		// the chat exists, so we'll return it to the client. If the creator had removed the chat it's
+		// restored since they're starting the conversation again.
+		chatID := existing.Id
+		if err := unhideChat(chatID, creatorID); err != nil {
+			logger.Errorf("Error deleting from the store. Chat ID: %v. Error: %v", chatID, err)
+			return errors.InternalServerError("chat.New.Unknown", "Error deleting from the store")
+		}
+		rsp.ChatId = chatID
 		return nil
-	} else if err != store.ErrNotFound {
+	} else if err != nil && err != store.ErrNotFound {
 		// if no records were found then we'd expect to get a store.ErrNotFound error returned. If this
 		// wasn't the case, the service could've experienced an issue connecting to the store so we should
 		// log the error and return an InternalServerError to the client, indicating the request should
@@ -58,12 +98,35 @@ func (c *Chat) New(ctx context.Context, req *pb.NewRequest, rsp *pb.NewResponse)
 		return errors.InternalServerError("chat.New.Unknown", "Error reading from the store")
 	}
 
-	// no chat id was returned so we'll generate one, write it to the store and then return it to the
-	// client
-	chatID := uuid.New().String()
-	record := store.Record{Key: chatStoreKeyPrefix + chatID, Value: []byte(chatID)}
-	if err := store.Write(&record); err != nil {
-		logger.Errorf("Error writing to the store. Key: %v. Error: %v", record.Key, err)
+	// no chat id was returned so we'll generate one, write the chat to the store and then return it
+	// to the client
+	chat := &pb.ChatInfo{
+		Id:        uuid.New().String(),
+		UserIds:   req.UserIds,
+		CreatorId: creatorID,
+		CreatedAt: unixMillis(time.Now()),
+		Title:     req.Title,
+		Roles:     map[string]pb.Role{creatorID: pb.Role_OWNER},
+	}
+	chatID := chat.Id
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chatID, err)
+		return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
+	}
+
+	// index the chat against each of its users so the chats a user is part of can be listed. The chat
+	// has no messages yet, so its users don't need a read cursor for their unread count to start at zero.
+	for _, userID := range req.UserIds {
+		if err := addMember(chatID, userID); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chatID, userID, err)
+			return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
+		}
+	}
+
+	// index the chat by its users so all the chats between them can be listed. If the users don't
+	// have a default chat this becomes their default, so subsequent calls return the same chat.
+	if err := indexParticipants(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chatID, err)
 		return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
 	}
 
@@ -73,3 +136,67 @@ func (c *Chat) New(ctx context.Context, req *pb.NewRequest, rsp *pb.NewResponse)
 	rsp.ChatId = chatID
 	return nil
 }
+
+// participantsKey returns the key identifying a set of users, regardless of the order of the ids.
+// We'll make a copy of the user ids as it's a good practice to not mutate the request object.
+func participantsKey(userIDs []string) string {
+	sortedIDs := make([]string, len(userIDs))
+	copy(sortedIDs, userIDs)
+	sort.Strings(sortedIDs)
+	return strings.Join(sortedIDs, "-")
+}
+
+// containsString returns true if the slice contains the string
+func containsString(slice []string, s string) bool {
+	for _, v := range slice {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// backfillUsers records the users of a chat created before the users of chats were recorded. The chat
+// is read again once locked, in case the users were recorded by a concurrent call. The creator wasn't
+// recorded either, so the user calling New is recorded as the creator and becomes the owner, otherwise
+// nobody could ever manage the chat.
+func (c *Chat) backfillUsers(chatID, creatorID string, userIDs []string) error {
+	unlock, err := c.lock("chat.New", chatStoreKeyPrefix+chatID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := readChat(chatID)
+	if err != nil {
+		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
+		return errors.InternalServerError("chat.New.Unknown", "Error reading from the store")
+	}
+	if len(chat.UserIds) > 0 {
+		return nil
+	}
+
+	chat.UserIds = append([]string{}, userIDs...)
+	chat.CreatorId = creatorID
+	chat.Roles = map[string]pb.Role{creatorID: pb.Role_OWNER}
+	for _, userID := range chat.UserIds {
+		if err := addMember(chatID, userID); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chatID, userID, err)
+			return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
+		}
+		// the history of the chat predates unread counts, so it's treated as read
+		if err := c.seedReadCursor(chatID, userID); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chatID, userID, err)
+			return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
+		}
+	}
+	if err := indexParticipants(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chatID, err)
+		return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
+	}
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chatID, err)
+		return errors.InternalServerError("chat.New.Unknown", "Error writing to the store")
+	}
+	return nil
+}
@@ -9,21 +9,117 @@ import (
 	"github.com/micro/micro/v3/service/store"
 )
 
-// Remove a chat, is
+// Remove a chat, by default the chat is only hidden from the user removing it and can be restored
+// by calling Restore. If the chat is destroyed it's removed for all of its users along with its
+// history, only owners and admins can destroy a chat.
 func (c *Chat) Remove(ctx context.Context, req *pb.RemoveRequest, rsp *pb.RemoveResponse) error {
+	// identify the user removing the chat
+	userID, err := identify(ctx, "chat.Remove", req.UserId)
+	if err != nil {
+		return err
+	}
 
 	// validate the request
 	if len(req.ChatId) == 0 {
-		return errors.BadRequest("chat.History.MissingChatID", "ChatID is missing")
+		return errors.BadRequest("chat.Remove.MissingChatID", "ChatID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.Remove.MissingUserID", "UserID is missing")
+	}
+
+	// ensure the user is part of the chat they're attempting to remove
+	chat, err := authorize("chat.Remove", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	rsp.ChatId = chat.Id
+
+	// hide the chat from the user, the chat is left intact for the other users
+	if !req.IsDestoryed {
+		if err := hideChat(chat.Id, userID); err != nil {
+			logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+			return errors.InternalServerError("chat.Remove.Unknown", "Error writing to the store")
+		}
+		return nil
 	}
 
-	// lookup the chat from the store to ensure it's valid
-	if err := store.Delete(chatStoreKeyPrefix + req.ChatId); err == store.ErrNotFound {
-		return errors.BadRequest("chat.History.InvalidChatID", "Chat not found with this ID")
-	} else if err != nil {
-		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", req.ChatId, err)
-		return errors.InternalServerError("chat.History.Unknown", "Error reading from the store")
+	// destroying the chat is serialised with changes to its users, so a concurrent change can't index
+	// the chat again once it's been destroyed. The chat is read again once locked so the role of the
+	// user is current.
+	unlock, err := c.lock("chat.Remove", chatStoreKeyPrefix+chat.Id)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
+	if chat, err = authorize("chat.Remove", req.ChatId, userID); err != nil {
+		return err
+	}
+	if !canManage(chat, userID) {
+		return errors.Forbidden("chat.Remove.Forbidden", "Only an owner or admin can destroy the chat")
+	}
+	if err := c.destroyChat(chat); err != nil {
+		logger.Errorf("Error destroying chat. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.Remove.Unknown", "Error deleting from the store")
+	}
+	logger.Infof("Chat destroyed with ID %v", chat.Id)
 	return nil
 }
+
+// destroyChat deletes the chat and everything recorded against it. The chat record is deleted last so
+// that if an error occurs the chat can still be found and the request retried. The caller must hold
+// the lock of the chat.
+func (c *Chat) destroyChat(chat *pb.ChatInfo) error {
+	// delete the messages sent to the chat along with their revisions, and then remove the chat from
+	// the search indexes
+	messages, err := c.repo.ListByChat(chat.Id, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := deleteRevisions(msg.Id); err != nil {
+			return err
+		}
+		if err := c.repo.Delete(msg.Id); err != nil {
+			return err
+		}
+	}
+	if err := c.unindexChat(chat.Id); err != nil {
+		return err
+	}
+
+	// delete the client ids recorded to make sending messages retry safe. The event stream itself is
+	// append-only so the events can't be deleted, however the history is read from the messages table
+	// and the events expire with the stream's retention.
+	messageKeys, err := store.List(store.ListPrefix(messageStoreKeyPrefix + chat.Id + "/"))
+	if err != nil {
+		return err
+	}
+	for _, key := range messageKeys {
+		if err := deleteKey(key); err != nil {
+			return err
+		}
+	}
+
+	// delete the indexes of the chat, a public room is indexed by its slug rather than its users
+	if chat.Public {
+		if err := deleteKey(roomStoreKeyPrefix + chat.Slug); err != nil {
+			return err
+		}
+	} else if err := unindexParticipants(chat); err != nil {
+		return err
+	}
+	if err := deleteKey(activityStoreKeyPrefix + chat.Id); err != nil {
+		return err
+	}
+	if err := deleteKey(sequenceStoreKeyPrefix + chat.Id); err != nil {
+		return err
+	}
+	for _, userID := range chat.UserIds {
+		if err := removeMember(chat.Id, userID); err != nil {
+			return err
+		}
+	}
+
+	return store.Delete(chatStoreKeyPrefix + chat.Id)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// Restore a chat which was removed by the user without being destroyed
+func (c *Chat) Restore(ctx context.Context, req *pb.RestoreRequest, rsp *pb.RestoreResponse) error {
+	// identify the user restoring the chat
+	userID, err := identify(ctx, "chat.Restore", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.Restore.MissingChatID", "ChatID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.Restore.MissingUserID", "UserID is missing")
+	}
+
+	// a destroyed chat can't be found so it can't be restored
+	chat, err := authorize("chat.Restore", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := unhideChat(chat.Id, userID); err != nil {
+		logger.Errorf("Error deleting from the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.Restore.Unknown", "Error deleting from the store")
+	}
+	rsp.ChatId = chat.Id
+	return nil
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// SetRole sets the role of a user in a chat. Owners can set any role, admins can only make users
+// members or read only and can't change the role of owners or other admins. A chat always has at
+// least one owner, so its last owner can only step down by transferring the ownership.
+func (c *Chat) SetRole(ctx context.Context, req *pb.SetRoleRequest, rsp *pb.SetRoleResponse) error {
+	// identify the user setting the role
+	userID, err := identify(ctx, "chat.SetRole", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.SetRole.MissingChatID", "ChatID is missing")
+	}
+	if len(req.MemberId) == 0 {
+		return errors.BadRequest("chat.SetRole.MissingMemberID", "MemberID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.SetRole.MissingUserID", "UserID is missing")
+	}
+	if _, ok := pb.Role_name[int32(req.Role)]; !ok {
+		return errors.BadRequest("chat.SetRole.InvalidRole", "Role is invalid")
+	}
+
+	unlock, err := c.lock("chat.SetRole", chatStoreKeyPrefix+req.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := authorize("chat.SetRole", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	if !containsString(chat.UserIds, req.MemberId) {
+		return errors.BadRequest("chat.SetRole.InvalidMemberID", "The user isn't part of this chat")
+	}
+
+	// admins can only manage the users ranked below them
+	role := roleOf(chat, userID)
+	current := roleOf(chat, req.MemberId)
+	if role != pb.Role_OWNER && (role != pb.Role_ADMIN || rank(current) >= rank(role) || rank(req.Role) >= rank(role)) {
+		return errors.Forbidden("chat.SetRole.Forbidden", "Not allowed to set this role")
+	}
+	if current == req.Role {
+		rsp.Chat = chat
+		return nil
+	}
+	if current == pb.Role_OWNER && len(owners(chat)) <= 1 {
+		return errors.BadRequest("chat.SetRole.LastOwner", "The last owner must transfer the ownership")
+	}
+
+	setRole(chat, req.MemberId, req.Role)
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.SetRole.Unknown", "Error writing to the store")
+	}
+	text := fmt.Sprintf("%v made %v %v", userID, req.MemberId, roleName(req.Role))
+	c.announce(chat.Id, userID, text)
+
+	rsp.Chat = chat
+	return nil
+}
+
+// TransferOwnership makes another user of the chat its owner, the previous owner becomes an admin
+func (c *Chat) TransferOwnership(ctx context.Context, req *pb.TransferOwnershipRequest, rsp *pb.TransferOwnershipResponse) error {
+	// identify the owner
+	userID, err := identify(ctx, "chat.TransferOwnership", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.TransferOwnership.MissingChatID", "ChatID is missing")
+	}
+	if len(req.NewOwnerId) == 0 {
+		return errors.BadRequest("chat.TransferOwnership.MissingNewOwnerID", "NewOwnerID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.TransferOwnership.MissingUserID", "UserID is missing")
+	}
+
+	unlock, err := c.lock("chat.TransferOwnership", chatStoreKeyPrefix+req.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := authorize("chat.TransferOwnership", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	if roleOf(chat, userID) != pb.Role_OWNER {
+		return errors.Forbidden("chat.TransferOwnership.Forbidden", "Only an owner can transfer the ownership")
+	}
+	if !containsString(chat.UserIds, req.NewOwnerId) {
+		return errors.BadRequest("chat.TransferOwnership.InvalidNewOwnerID", "The user isn't part of this chat")
+	}
+	if req.NewOwnerId == userID {
+		rsp.Chat = chat
+		return nil
+	}
+
+	setRole(chat, req.NewOwnerId, pb.Role_OWNER)
+	setRole(chat, userID, pb.Role_ADMIN)
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.TransferOwnership.Unknown", "Error writing to the store")
+	}
+	c.announce(chat.Id, userID, fmt.Sprintf("%v made %v the owner", userID, req.NewOwnerId))
+
+	rsp.Chat = chat
+	return nil
+}
+
+// announce records a change to the chat in its history as a system message. The change has already
+// been made, so errors are only logged.
+func (c *Chat) announce(chatID, actorID, text string) {
+	msg := &pb.Message{ChatId: chatID, UserId: actorID, Text: text, System: true}
+	if _, _, err := c.createMessage(msg, ""); err != nil {
+		logger.Errorf("Error creating system message. Chat ID: %v. Error: %v", chatID, err)
+	}
+}
+
+// roleName returns the name of the role as it's shown in system messages, e.g. "an admin"
+func roleName(role pb.Role) string {
+	switch role {
+	case pb.Role_OWNER:
+		return "an owner"
+	case pb.Role_ADMIN:
+		return "an admin"
+	case pb.Role_READ_ONLY:
+		return "read only"
+	default:
+		return "a " + strings.ToLower(role.String())
+	}
+}
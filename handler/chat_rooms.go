@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+)
+
+const (
+	// defaultRoomsLimit is the number of rooms returned when the client doesn't specify a limit
+	defaultRoomsLimit = 20
+	// maxRoomsLimit is the upper bound of rooms returned by a single call
+	maxRoomsLimit = 100
+)
+
+// slugPattern matches the valid room slugs, e.g. release-train
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// CreateRoom creates a public room, which any user can find using ListRooms and join using JoinRoom.
+// Rooms are identified by a unique slug, and the user creating the room becomes its owner.
+func (c *Chat) CreateRoom(ctx context.Context, req *pb.CreateRoomRequest, rsp *pb.CreateRoomResponse) error {
+	// identify the user creating the room
+	userID, err := identify(ctx, "chat.CreateRoom", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	slug := normalizeSlug(req.Slug)
+	if len(slug) == 0 {
+		return errors.BadRequest("chat.CreateRoom.MissingSlug", "Slug is missing")
+	}
+	if len(slug) > 64 || !slugPattern.MatchString(slug) {
+		return errors.BadRequest("chat.CreateRoom.InvalidSlug", "Slug must be lower case letters, digits and dashes")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.CreateRoom.MissingUserID", "UserID is missing")
+	}
+
+	// rooms with the same slug are created one at a time across all the instances of the service, so
+	// only one of them can take the slug
+	key := roomStoreKeyPrefix + slug
+	unlock, err := c.lock("chat.CreateRoom", key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the slug can be reused once the room using it has been destroyed
+	if _, err := readRoom(slug); err == nil {
+		return errors.Conflict("chat.CreateRoom.SlugTaken", "A room already exists with this slug")
+	} else if err != store.ErrNotFound {
+		logger.Errorf("Error reading from the store. Key: %v. Error: %v", key, err)
+		return errors.InternalServerError("chat.CreateRoom.Unknown", "Error reading from the store")
+	}
+
+	// the room is a chat, rooms aren't indexed by their users since they aren't a conversation between
+	// them and so are never the default chat for the users
+	chat := &pb.ChatInfo{
+		Id:        uuid.New().String(),
+		UserIds:   []string{userID},
+		CreatorId: userID,
+		CreatedAt: unixMillis(time.Now()),
+		Title:     req.Title,
+		Topic:     req.Topic,
+		Roles:     map[string]pb.Role{userID: pb.Role_OWNER},
+		Public:    true,
+		Slug:      slug,
+	}
+
+	// the slug is claimed before the room is written, so a room is never created without its slug. If
+	// writing the room fails the slug points at a chat which doesn't exist, and is free to use again.
+	if err := store.Write(&store.Record{Key: key, Value: []byte(chat.Id)}); err != nil {
+		logger.Errorf("Error writing to the store. Key: %v. Error: %v", key, err)
+		return errors.InternalServerError("chat.CreateRoom.Unknown", "Error writing to the store")
+	}
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.CreateRoom.Unknown", "Error writing to the store")
+	}
+
+	// the lock expires if holding it takes longer than its TTL, in which case another room could've
+	// claimed the slug since. The slug is read again so the room is only kept if it still owns it.
+	if recs, err := store.Read(key); err != nil {
+		logger.Errorf("Error reading from the store. Key: %v. Error: %v", key, err)
+		return errors.InternalServerError("chat.CreateRoom.Unknown", "Error reading from the store")
+	} else if string(recs[0].Value) != chat.Id {
+		if err := deleteKey(chatStoreKeyPrefix + chat.Id); err != nil {
+			logger.Errorf("Error deleting from the store. Chat ID: %v. Error: %v", chat.Id, err)
+		}
+		return errors.Conflict("chat.CreateRoom.SlugTaken", "A room already exists with this slug")
+	}
+	if err := addMember(chat.Id, userID); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. User ID: %v. Error: %v", chat.Id, userID, err)
+		return errors.InternalServerError("chat.CreateRoom.Unknown", "Error writing to the store")
+	}
+
+	logger.Infof("New room created with ID %v", chat.Id)
+	rsp.Chat = chat
+	return nil
+}
+
+// ListRooms returns the public rooms ordered by their slug, optionally only the rooms with a slug,
+// title or topic containing the query
+func (c *Chat) ListRooms(ctx context.Context, req *pb.ListRoomsRequest, rsp *pb.ListRoomsResponse) error {
+	// any user can list the rooms
+	if _, err := identify(ctx, "chat.ListRooms", ""); err != nil {
+		return err
+	}
+
+	// validate the request
+	if req.Limit < 0 || req.Offset < 0 {
+		return errors.BadRequest("chat.ListRooms.InvalidLimit", "Limit and offset cannot be negative")
+	}
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultRoomsLimit
+	} else if limit > maxRoomsLimit {
+		limit = maxRoomsLimit
+	}
+
+	recs, err := store.Read(roomStoreKeyPrefix, store.ReadPrefix())
+	if err != nil && err != store.ErrNotFound {
+		logger.Errorf("Error reading from the store. Error: %v", err)
+		return errors.InternalServerError("chat.ListRooms.Unknown", "Error reading from the store")
+	}
+
+	query := normalizeSlug(req.Query)
+	var rooms []*pb.ChatInfo
+	for _, rec := range recs {
+		chat, err := readChat(string(rec.Value))
+		if err == store.ErrNotFound {
+			// the room has since been destroyed
+			continue
+		} else if err != nil {
+			logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", string(rec.Value), err)
+			return errors.InternalServerError("chat.ListRooms.Unknown", "Error reading from the store")
+		}
+		if len(query) > 0 && !strings.Contains(chat.Slug, query) &&
+			!strings.Contains(strings.ToLower(chat.Title), query) && !strings.Contains(strings.ToLower(chat.Topic), query) {
+			continue
+		}
+		rooms = append(rooms, chat)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Slug < rooms[j].Slug })
+
+	if int(req.Offset) >= len(rooms) {
+		rsp.Rooms = []*pb.ChatInfo{}
+		return nil
+	}
+	rooms = rooms[req.Offset:]
+	if len(rooms) > limit {
+		rooms = rooms[:limit]
+	}
+	rsp.Rooms = rooms
+	return nil
+}
+
+// JoinRoom adds the user to a public room. The join is recorded in the history of the room and the
+// users connected to the room are notified.
+func (c *Chat) JoinRoom(ctx context.Context, req *pb.JoinRoomRequest, rsp *pb.JoinRoomResponse) error {
+	// users can only join rooms themselves
+	userID, err := identify(ctx, "chat.JoinRoom", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	slug := normalizeSlug(req.Slug)
+	if len(slug) == 0 {
+		return errors.BadRequest("chat.JoinRoom.MissingSlug", "Slug is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.JoinRoom.MissingUserID", "UserID is missing")
+	}
+
+	chat, err := readRoom(slug)
+	if err == store.ErrNotFound {
+		return errors.NotFound("chat.JoinRoom.NotFound", "Room not found with this slug")
+	} else if err != nil {
+		logger.Errorf("Error reading from the store. Slug: %v. Error: %v", slug, err)
+		return errors.InternalServerError("chat.JoinRoom.Unknown", "Error reading from the store")
+	}
+
+	// the room is read again once locked so the users are current
+	chatID := chat.Id
+	unlock, err := c.lock("chat.JoinRoom", chatStoreKeyPrefix+chatID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if chat, err = readChat(chatID); err == store.ErrNotFound {
+		return errors.NotFound("chat.JoinRoom.NotFound", "Room not found with this slug")
+	} else if err != nil {
+		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chatID, err)
+		return errors.InternalServerError("chat.JoinRoom.Unknown", "Error reading from the store")
+	}
+	if containsString(chat.UserIds, userID) {
+		rsp.Chat = chat
+		return nil
+	}
+
+	users := append(append([]string{}, chat.UserIds...), userID)
+	if err := c.setMembers("chat.JoinRoom", chat, users); err != nil {
+		return err
+	}
+	c.announceMembers(chat.Id, userID, []string{userID}, true, fmt.Sprintf("%v joined", userID))
+
+	rsp.Chat = chat
+	return nil
+}
+
+// readRoom loads the room with the slug. If the room doesn't exist store.ErrNotFound is returned.
+func readRoom(slug string) (*pb.ChatInfo, error) {
+	recs, err := store.Read(roomStoreKeyPrefix + slug)
+	if err != nil {
+		return nil, err
+	}
+	return readChat(string(recs[0].Value))
+}
+
+// normalizeSlug returns the slug without the leading #, so #release-train and release-train are the
+// same room
+func normalizeSlug(slug string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(slug), "#"))
+}
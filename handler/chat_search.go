@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/micro-community/micro-chat/model"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/store"
+)
+
+const (
+	// defaultSearchLimit is the number of results returned when the client doesn't specify a limit
+	defaultSearchLimit = 20
+	// maxSearchLimit is the upper bound of results returned by a single search
+	maxSearchLimit = 100
+)
+
+// Search the messages in the chats the user is part of
+func (c *Chat) Search(ctx context.Context, req *pb.SearchRequest, rsp *pb.SearchResponse) error {
+	// identify the user searching, they can only search the chats they're part of
+	userID, err := identify(ctx, "chat.Search", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.Search.MissingUserID", "UserID is missing")
+	}
+	if len(req.Query) == 0 {
+		return errors.BadRequest("chat.Search.MissingQuery", "Query is missing")
+	}
+	if req.FromTimestamp < 0 || req.ToTimestamp < 0 {
+		return errors.BadRequest("chat.Search.InvalidTimestamp", "Timestamps cannot be negative")
+	}
+	if req.ToTimestamp > 0 && req.FromTimestamp > req.ToTimestamp {
+		return errors.BadRequest("chat.Search.InvalidTimeRange", "FromTimestamp must not be after ToTimestamp")
+	}
+	if req.Limit < 0 || req.Offset < 0 {
+		return errors.BadRequest("chat.Search.InvalidLimit", "Limit and offset cannot be negative")
+	}
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = defaultSearchLimit
+	} else if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	// determine the chats to search, either the one requested or all the chats the user is part of
+	var chatIDs []string
+	if len(req.ChatId) > 0 {
+		if _, err := authorize("chat.Search", req.ChatId, userID); err != nil {
+			return err
+		}
+		chatIDs = []string{req.ChatId}
+	} else {
+		recs, err := store.Read(memberStoreKeyPrefix+userID+"/", store.ReadPrefix())
+		if err != nil && err != store.ErrNotFound {
+			logger.Errorf("Error reading from the store. User ID: %v. Error: %v", userID, err)
+			return errors.InternalServerError("chat.Search.Unknown", "Error reading from the store")
+		}
+		for _, rec := range recs {
+			chatIDs = append(chatIDs, string(rec.Value))
+		}
+	}
+
+	// the index is local to this instance of the service, so the chats which haven't been searched
+	// before are loaded into it from the messages table. The instance subscribes to the changes made by
+	// the other instances first, so the changes made while a chat is being loaded aren't missed.
+	if err := c.subscribeIndex(); err != nil {
+		logger.Errorf("Error subscribing to the search index changes. Error: %v", err)
+		return errors.InternalServerError("chat.Search.Unknown", "Error connecting to the broker")
+	}
+	for _, chatID := range chatIDs {
+		if !c.index.StartLoad(chatID) {
+			continue
+		}
+		messages, err := c.repo.ListByChat(chatID, 0, 0)
+		if err != nil {
+			c.index.Unload(chatID)
+			logger.Errorf("Error reading from the messages table. Chat ID: %v. Error: %v", chatID, err)
+			return errors.InternalServerError("chat.Search.Unknown", "Error reading from the messages table")
+		}
+		c.index.Load(chatID, messages)
+	}
+
+	results := c.index.Search(model.SearchQuery{
+		Text:    req.Query,
+		ChatIDs: chatIDs,
+		UserID:  req.SenderId,
+		From:    req.FromTimestamp,
+		To:      req.ToTimestamp,
+		Limit:   limit,
+		Offset:  int(req.Offset),
+	})
+	rsp.Results = make([]*pb.SearchResult, len(results))
+	for i, r := range results {
+		rsp.Results[i] = &pb.SearchResult{Message: r.Message, Snippet: r.Snippet}
+	}
+	return nil
+}
@@ -3,39 +3,66 @@ package handler
 import (
 	"context"
 
-	"github.com/google/uuid"
 	pb "github.com/micro-community/micro-chat/proto"
 	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
 )
 
 // Send a single message to the chat, designed for ease of use via the API / CLI
 func (c *Chat) Send(ctx context.Context, req *pb.SendRequest, rsp *pb.SendResponse) error {
+	// identify the user sending the message, so users can't send messages as somebody else
+	userID, err := identify(ctx, "chat.Send", req.UserId)
+	if err != nil {
+		return err
+	}
+
 	// validate the request
 	if len(req.ChatId) == 0 {
 		return errors.BadRequest("chat.Send.MissingChatID", "ChatID is missing")
 	}
-	if len(req.UserId) == 0 {
+	if len(userID) == 0 {
 		return errors.BadRequest("chat.Send.MissingUserID", "UserID is missing")
 	}
 	if len(req.Text) == 0 {
 		return errors.BadRequest("chat.Send.MissingText", "Text is missing")
 	}
 
+	// ensure the user is part of the chat they're sending the message to, and is allowed to send to it
+	chat, err := authorize("chat.Send", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	if roleOf(chat, userID) == pb.Role_READ_ONLY {
+		return errors.Forbidden("chat.Send.ReadOnly", "Read only users can't send messages")
+	}
+
 	// construct the message
 	msg := &pb.Message{
-		Id:       uuid.New().String(),
 		ClientId: req.ClientId,
 		ChatId:   req.ChatId,
-		UserId:   req.UserId,
+		UserId:   userID,
 		Subject:  req.Subject,
 		Text:     req.Text,
+		// the time the client sent the message is only kept for diagnostics, the server stamps the
+		// message with the time it was received
+		ClientSentAt: req.SentAt,
+		ReplyToId:    req.ReplyToId,
 	}
 
-	// default the client id if not provided
-	if len(msg.ClientId) == 0 {
-		msg.ClientId = uuid.New().String()
+	// a reply is added to the thread of the message it replies to
+	if err := c.resolveReply("chat.Send", msg); err != nil {
+		return err
 	}
 
-	// create the message
-	return c.createMessage(msg)
+	// create the message and return the id allocated to it. If the client id had been used before
+	// the message isn't created again, and the original message is returned instead.
+	msg, duplicate, err := c.createMessage(msg, "")
+	if err != nil {
+		logger.Errorf("Error creating message. Chat ID: %v. Error: %v", req.ChatId, err)
+		return errors.InternalServerError("chat.Send.Unknown", "Error creating the message")
+	}
+	rsp.MessageId = msg.Id
+	rsp.SentAt = msg.SentAt
+	rsp.Duplicate = duplicate
+	return nil
 }
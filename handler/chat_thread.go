@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"sort"
+
+	"github.com/micro-community/micro-chat/model"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// GetThread returns the first message of a thread along with all of its replies, oldest first. The
+// thread can be requested using the id of any message in it.
+func (c *Chat) GetThread(ctx context.Context, req *pb.GetThreadRequest, rsp *pb.GetThreadResponse) error {
+	// identify the user getting the thread
+	userID, err := identify(ctx, "chat.GetThread", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.MessageId) == 0 {
+		return errors.BadRequest("chat.GetThread.MissingMessageID", "MessageID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.GetThread.MissingUserID", "UserID is missing")
+	}
+
+	// load the message, ensuring the user is part of its chat, and then the first message of the
+	// thread if the message is a reply
+	root, err := c.readMessage("chat.GetThread", req.MessageId, userID)
+	if err != nil {
+		return err
+	}
+	if len(root.ThreadRootId) > 0 {
+		if root, err = c.readMessage("chat.GetThread", root.ThreadRootId, userID); err != nil {
+			return err
+		}
+	}
+
+	replies, err := c.repo.ListByThread(root.Id)
+	if err != nil {
+		logger.Errorf("Error reading from the messages table. Message ID: %v. Error: %v", root.Id, err)
+		return errors.InternalServerError("chat.GetThread.Unknown", "Error reading from the messages table")
+	}
+	sort.Slice(replies, func(i, j int) bool {
+		return positionBefore(replies[i].SentAt, replies[i].Id, replies[j].SentAt, replies[j].Id)
+	})
+	root.ReplyCount = replyCounts(replies)[root.Id]
+
+	rsp.Root = root
+	rsp.Replies = replies
+	return nil
+}
+
+// resolveReply adds a reply to the thread of the message it replies to. Replies to a reply are added
+// to the same thread, so threads are never nested. The thread is always set by the server. The id
+// passed is used as the prefix of the errors returned.
+func (c *Chat) resolveReply(id string, msg *pb.Message) error {
+	msg.ThreadRootId = ""
+	msg.ReplyCount = 0
+	if len(msg.ReplyToId) == 0 {
+		return nil
+	}
+
+	// the message replied to must be in the same chat
+	parent, err := c.repo.Read(msg.ReplyToId)
+	if err == model.ErrNotFound || (err == nil && parent.ChatId != msg.ChatId) {
+		return errors.BadRequest(id+".InvalidReplyToID", "Message not found with this ID")
+	} else if err != nil {
+		logger.Errorf("Error reading message. Message ID: %v. Error: %v", msg.ReplyToId, err)
+		return errors.InternalServerError(id+".Unknown", "Error reading the message")
+	}
+
+	msg.ThreadRootId = parent.ThreadRootId
+	if len(msg.ThreadRootId) == 0 {
+		msg.ThreadRootId = parent.Id
+	}
+	return nil
+}
+
+// countReply changes the number of replies recorded on the first message of a thread, so the history
+// doesn't have to read the replies of every thread it returns. The count is changed while holding the
+// lock of the message, so it's serialised with the message being edited or deleted.
+func (c *Chat) countReply(rootID string, delta int64) error {
+	unlock, err := c.acquireLock(revisionStoreKeyPrefix + rootID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	root, err := c.repo.Read(rootID)
+	if err != nil {
+		return err
+	}
+	root.ReplyCount += delta
+	if root.ReplyCount < 0 {
+		root.ReplyCount = 0
+	}
+	return c.repo.Update(root)
+}
+
+// replyCounts returns the number of replies in each thread, keyed by the id of the first message of
+// the thread. Deleted replies aren't counted.
+func replyCounts(messages []*pb.Message) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, msg := range messages {
+		if len(msg.ThreadRootId) > 0 && !msg.Deleted {
+			counts[msg.ThreadRootId]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+)
+
+// UpdateChat changes the title, topic, avatar or labels of a chat. Only the fields listed in the
+// request are changed, and only owners and admins can change them. The users connected to the chat
+// receive the updated chat.
+func (c *Chat) UpdateChat(ctx context.Context, req *pb.UpdateChatRequest, rsp *pb.UpdateChatResponse) error {
+	// identify the user changing the chat
+	userID, err := identify(ctx, "chat.UpdateChat", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.UpdateChat.MissingChatID", "ChatID is missing")
+	}
+	if len(req.Fields) == 0 {
+		return errors.BadRequest("chat.UpdateChat.MissingFields", "One or more fields are required")
+	}
+	for _, field := range req.Fields {
+		switch field {
+		case "title", "topic", "avatar", "labels":
+		default:
+			return errors.BadRequest("chat.UpdateChat.InvalidField", fmt.Sprintf("%v can't be updated", field))
+		}
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.UpdateChat.MissingUserID", "UserID is missing")
+	}
+
+	unlock, err := c.lock("chat.UpdateChat", chatStoreKeyPrefix+req.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	chat, err := authorize("chat.UpdateChat", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	if !canManage(chat, userID) {
+		return errors.Forbidden("chat.UpdateChat.Forbidden", "Only an owner or admin can update the chat")
+	}
+
+	renamed := false
+	for _, field := range req.Fields {
+		switch field {
+		case "title":
+			renamed = chat.Title != req.Title
+			chat.Title = req.Title
+		case "topic":
+			chat.Topic = req.Topic
+		case "avatar":
+			chat.Avatar = req.Avatar
+		case "labels":
+			chat.Labels = req.Labels
+		}
+	}
+	if err := writeChat(chat); err != nil {
+		logger.Errorf("Error writing to the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.UpdateChat.Unknown", "Error writing to the store")
+	}
+
+	// renaming the chat is recorded in its history, the users connected are sent the updated chat.
+	// The change has already been made, so errors are only logged.
+	if renamed {
+		c.announce(chat.Id, userID, fmt.Sprintf("%v renamed the chat to %v", userID, chat.Title))
+	}
+	ev := &pb.ChatEvent{ChatId: chat.Id, Event: &pb.ChatEvent_ChatUpdated{ChatUpdated: chat}}
+	if err := publishEvent(ev); err != nil {
+		logger.Errorf("Error publishing event. Chat ID: %v. Error: %v", chat.Id, err)
+	}
+
+	rsp.Chat = chat
+	return nil
+}
+
+// GetChat returns a chat the user is part of, along with the time it was last active and the number
+// of messages the user hasn't read
+func (c *Chat) GetChat(ctx context.Context, req *pb.GetChatRequest, rsp *pb.GetChatResponse) error {
+	// identify the user getting the chat
+	userID, err := identify(ctx, "chat.GetChat", req.UserId)
+	if err != nil {
+		return err
+	}
+
+	// validate the request
+	if len(req.ChatId) == 0 {
+		return errors.BadRequest("chat.GetChat.MissingChatID", "ChatID is missing")
+	}
+	if len(userID) == 0 {
+		return errors.BadRequest("chat.GetChat.MissingUserID", "UserID is missing")
+	}
+
+	chat, err := authorize("chat.GetChat", req.ChatId, userID)
+	if err != nil {
+		return err
+	}
+	if chat.LastActivityAt, err = readLastActivity(chat.Id); err != nil {
+		logger.Errorf("Error reading from the store. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.GetChat.Unknown", "Error reading from the store")
+	}
+	if chat.UnreadCount, err = c.unreadCount(chat.Id, userID); err != nil {
+		logger.Errorf("Error counting unread messages. Chat ID: %v. Error: %v", chat.Id, err)
+		return errors.InternalServerError("chat.GetChat.Unknown", "Error reading from the store")
+	}
+
+	rsp.Chat = chat
+	return nil
+}
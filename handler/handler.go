@@ -3,53 +3,208 @@ package handler
 // it's standard to import the services own proto under the alias pb
 
 import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/micro-community/micro-chat/model"
 	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/broker"
 	"github.com/micro/micro/v3/service/events"
 	"github.com/micro/micro/v3/service/store"
 )
 
 const (
-	chatStoreKeyPrefix    = "chats/"
-	chatEventKeyPrefix    = "chats/"
-	messageStoreKeyPrefix = "messages/"
+	chatStoreKeyPrefix        = "chats/"
+	chatEventKeyPrefix        = "chats/"
+	messageStoreKeyPrefix     = "messages/"
+	participantStoreKeyPrefix = "participants/"
+	memberStoreKeyPrefix      = "members/"
+	activityStoreKeyPrefix    = "activity/"
+	hiddenStoreKeyPrefix      = "hidden/"
+	revisionStoreKeyPrefix    = "revisions/"
+	sessionStoreKeyPrefix     = "sessions/"
+	onlineStoreKeyPrefix      = "online/"
+	lastSeenStoreKeyPrefix    = "lastseen/"
+	readStoreKeyPrefix        = "reads/"
+	sequenceStoreKeyPrefix    = "sequences/"
+	roomStoreKeyPrefix        = "rooms/"
+	signalTopicPrefix         = "signals/"
+	searchIndexTopic          = "search"
+
+	// messageKeyExpiry is how long client ids are recorded for, retries after this are treated as new
+	// messages
+	messageKeyExpiry = time.Hour * 24
+
+	// typingTTL is how long a typing indicator lasts if the client doesn't renew or stop it
+	typingTTL = time.Second * 10
+
+	// maxIndexedMessages is the number of messages each instance holds in its search index, beyond
+	// which the least recently searched chats are evicted
+	maxIndexedMessages = 1000000
+
+	// presenceTTL is how long a session is recorded as connected for without a heartbeat. Sessions
+	// are renewed at half this interval, if the service dies the user goes offline once it expires.
+	presenceTTL = time.Minute
+
+	// maxUnreadCount is the most unread messages counted in a chat, clients show chats with this many as
+	// having 99+ unread messages
+	maxUnreadCount = 100
+
+	// clockSkew is how far behind the clocks of the instances of the service are allowed to be, sessions
+	// consume the events published up to this long before they connected so none are missed
+	clockSkew = time.Second * 10
+
+	// eventTypeKey is the metadata key of the events published to a chat which holds the type of the
+	// event, so consumers can tell a new message apart from an edit to an existing one
+	eventTypeKey     = "type"
+	eventTypeMessage = "message"
+	eventTypeEdit    = "edit"
+	eventTypeDelete  = "delete"
+	// eventTypeEvent is the type of the events, other than messages, published as a pb.ChatEvent
+	eventTypeEvent = "event"
+	// eventDeviceKey is the metadata key of the events published to a chat which holds the id of the
+	// device the event was sent from
+	eventDeviceKey = "device"
 )
 
 // Chat satisfies the ChatHandler interface. You can see this interface defined in chat.pb.micro.go
 type Chat struct {
 	Namespace string
 	repo      *model.Repository
+	index     *model.SearchIndex
+	// indexSub is the subscription to the changes made to the search index by every instance, it's
+	// created the first time a chat is searched
+	indexSub broker.Subscriber
+	indexMu  sync.Mutex
 }
 
 //New Return Chat Handler
 func New(namespace string) *Chat {
 	return &Chat{
 		Namespace: namespace,
-		repo:      model.NewRepository("messsages"),
+		repo:      model.NewRepository("messages"),
+		index:     model.NewSearchIndex(maxIndexedMessages),
 	}
 }
 
-// createMessage creates a message in the event stream. It handles the
-// logic for ensuring client id is unique.
-func (c *Chat) createMessage(msg *pb.Message) error {
+// sentMessage is recorded against the client id of a message to make creating it retry safe
+type sentMessage struct {
+	Message *pb.Message `json:"message"`
+	// Published is false until the message has been published to the event stream
+	Published bool `json:"published"`
+}
+
+// createMessage saves a message to the messages table and publishes it to
+// the event stream. It handles the logic for ensuring client id is unique. The message created is returned,
+// along with true if the client id had already been used in which case the
+// original message is returned. The device id identifies the device of the user which sent the
+// message, if any, so the message isn't sent back to it.
+func (c *Chat) createMessage(msg *pb.Message, deviceID string) (*pb.Message, bool, error) {
+	// default the client id if not provided, the message then can't be retried
+	if len(msg.ClientId) == 0 {
+		msg.ClientId = uuid.New().String()
+	}
+
+	// client ids are scoped to the user and the chat, so users can't suppress each others messages by
+	// reusing their client ids. Retries of the same message are serialised across the instances of
+	// the service so only one of them can create the message.
+	key := sentMessageKey(msg)
+	unlock, err := c.acquireLock(key)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
 	// a message was received from the client. validate it hasn't been received before
-	if _, err := store.Read(messageStoreKeyPrefix + msg.ClientId); err == nil {
-		// the message has already been processed
-		return nil
+	var sent sentMessage
+	var duplicate bool
+	if recs, err := store.Read(key); err == nil {
+		duplicate = true
+		if err := json.Unmarshal(recs[0].Value, &sent); err != nil {
+			return nil, false, err
+		}
+		// the message has already been processed, so the original message is returned
+		if sent.Published {
+			return sent.Message, true, nil
+		}
+		// the previous attempt failed before the message was recorded as published. It may or may not
+		// have been saved and published, so it's saved and published again using the same id and
+		// sequence which allows consumers to discard it if they've already received it.
 	} else if err != store.ErrNotFound {
 		// an unexpected error occurred
-		return err
+		return nil, false, err
+	} else {
+		// the id is always allocated by the server, an id provided by the client is ignored since it
+		// would allow the client to overwrite other messages
+		msg.Id = uuid.New().String()
+
+		// the time the message was sent is allocated by the server when it's saved, any time sent by
+		// the client is only kept for diagnostics
+		if msg.ClientSentAt == 0 {
+			msg.ClientSentAt = msg.SentAt
+		}
+		msg.SentAt = 0
+		msg.Sequence = 0
+
+		// record the message before it's published, so if publishing fails or the service crashes the
+		// retry publishes the same message rather than a new one
+		sent = sentMessage{Message: msg}
+		if err := writeSentMessage(key, &sent); err != nil {
+			return nil, false, err
+		}
 	}
 
-	// send the message to the event stream
-	if err := events.Publish(chatEventKeyPrefix+msg.ChatId, msg); err != nil {
-		return err
+	// save the message, the messages table is used to query the history of the chat. The message is
+	// given its position in the chat as it's saved, a retry of a message which was already saved keeps
+	// its position.
+	if err := c.saveMessage(sent.Message); err != nil {
+		return nil, false, err
+	}
+	if err := c.indexMessage(sent.Message); err != nil {
+		return nil, false, err
 	}
 
-	// record the messages client id
-	if err := store.Write(&store.Record{Key: messageStoreKeyPrefix + msg.ClientId}); err != nil {
-		return err
+	// send the message to the event stream, so it's received by the connected users
+	if err := publishMessage(eventTypeMessage, sent.Message, deviceID); err != nil {
+		return nil, false, err
+	}
+
+	// record the message as published
+	sent.Published = true
+	if err := writeSentMessage(key, &sent); err != nil {
+		return nil, false, err
+	}
+
+	// record the activity so the chats can be ordered by the most recently active
+	if err := writeLastActivity(sent.Message.ChatId, sent.Message.SentAt); err != nil {
+		return nil, false, err
 	}
 
-	return nil
+	return sent.Message, duplicate, nil
+}
+
+// publishMessage publishes the message to the event stream of its chat. The device id is recorded so
+// the device which made the change can skip it, it's blank if the change wasn't made over a stream.
+func publishMessage(eventType string, msg *pb.Message, deviceID string) error {
+	return events.Publish(chatEventKeyPrefix+msg.ChatId, msg, events.WithMetadata(map[string]string{
+		eventTypeKey:   eventType,
+		eventDeviceKey: deviceID,
+	}))
+}
+
+// sentMessageKey returns the key the message is recorded against to make creating it retry safe
+func sentMessageKey(msg *pb.Message) string {
+	return messageStoreKeyPrefix + msg.ChatId + "/" + msg.UserId + "/" + msg.ClientId
+}
+
+// writeSentMessage records the message against its client id. Retries are only expected shortly
+// after the message is sent, so the record expires to stop the keyspace growing forever.
+func writeSentMessage(key string, sent *sentMessage) error {
+	bytes, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+	return store.Write(&store.Record{Key: key, Value: bytes, Expiry: messageKeyExpiry})
 }
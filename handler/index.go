@@ -0,0 +1,75 @@
+package handler
+
+import (
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/broker"
+	"github.com/micro/micro/v3/service/logger"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// every instance of the service holds its own search index, so the changes to the messages are sent to
+// every instance using the broker. An instance only receives the changes made while it's subscribed,
+// so it subscribes before loading any chat into its index.
+
+const (
+	// indexActionKey is the header of the changes sent to the search indexes which holds the action
+	indexActionKey = "action"
+	// indexActionUpdate updates the message in the body of the change
+	indexActionUpdate = "update"
+	// indexActionUnload removes the chat in the indexChatKey header from the index
+	indexActionUnload = "unload"
+	// indexChatKey is the header of the changes sent to the search indexes which holds the chat id
+	indexChatKey = "chat"
+)
+
+// indexMessage applies a change to a message to the search index of every instance. The index of this
+// instance is updated directly so the change can be searched as soon as it's made.
+func (c *Chat) indexMessage(msg *pb.Message) error {
+	c.index.Update(msg)
+	bytes, err := protojson.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return broker.Publish(searchIndexTopic, &broker.Message{
+		Header: map[string]string{indexActionKey: indexActionUpdate, indexChatKey: msg.ChatId},
+		Body:   bytes,
+	})
+}
+
+// unindexChat removes the chat from the search index of every instance
+func (c *Chat) unindexChat(chatID string) error {
+	c.index.Unload(chatID)
+	return broker.Publish(searchIndexTopic, &broker.Message{
+		Header: map[string]string{indexActionKey: indexActionUnload, indexChatKey: chatID},
+	})
+}
+
+// subscribeIndex subscribes to the changes sent to the search indexes, if it hasn't already. No queue
+// is used so every instance receives every change.
+func (c *Chat) subscribeIndex() error {
+	c.indexMu.Lock()
+	defer c.indexMu.Unlock()
+	if c.indexSub != nil {
+		return nil
+	}
+
+	sub, err := broker.Subscribe(searchIndexTopic, func(m *broker.Message) error {
+		switch m.Header[indexActionKey] {
+		case indexActionUpdate:
+			var msg pb.Message
+			if err := protojson.Unmarshal(m.Body, &msg); err != nil {
+				logger.Errorf("Error unmarshaling search index change. Chat ID: %v. Error: %v", m.Header[indexChatKey], err)
+				return err
+			}
+			c.index.Update(&msg)
+		case indexActionUnload:
+			c.index.Unload(m.Header[indexChatKey])
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.indexSub = sub
+	return nil
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/logger"
+	"github.com/micro/micro/v3/service/sync"
+)
+
+const (
+	// lockTTL is how long a lock is held for if the instance holding it dies before releasing it
+	lockTTL = time.Second * 30
+	// lockWait is how long to wait for a lock held by another call before giving up
+	lockWait = time.Second * 10
+)
+
+// acquireLock locks the key using the sync service, so operations on the same key are serialised
+// across all the instances of the service, operations on different keys can run concurrently. The
+// function returned unlocks it.
+func (c *Chat) acquireLock(key string) (func(), error) {
+	id := c.Namespace + "/" + key
+	if err := sync.DefaultSync.Lock(id, sync.LockTTL(lockTTL), sync.LockWait(lockWait)); err != nil {
+		return nil, err
+	}
+	return func() {
+		// the lock expires if it can't be released, so the error is only logged
+		if err := sync.DefaultSync.Unlock(id); err != nil {
+			logger.Errorf("Error releasing lock. Key: %v. Error: %v", id, err)
+		}
+	}, nil
+}
+
+// lock acquires the lock for the key, returning an error for the client if it can't be acquired. The
+// id passed is used as the prefix of the errors returned. Chats are read, changed and written back as
+// a whole, so they're only changed while holding the lock of their chatStoreKeyPrefix key, otherwise
+// concurrent changes would overwrite each other.
+func (c *Chat) lock(id, key string) (func(), error) {
+	unlock, err := c.acquireLock(key)
+	if err != nil {
+		logger.Errorf("Error acquiring lock. Key: %v. Error: %v", key, err)
+		return nil, errors.InternalServerError(id+".Unknown", "Error acquiring the lock")
+	}
+	return unlock, nil
+}
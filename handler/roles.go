@@ -0,0 +1,79 @@
+package handler
+
+import (
+	pb "github.com/micro-community/micro-chat/proto"
+)
+
+// roleOf returns the role of the user in the chat. Users without a role are members, and the creator
+// of a chat created before roles were introduced is its owner.
+func roleOf(chat *pb.ChatInfo, userID string) pb.Role {
+	if role, ok := chat.Roles[userID]; ok {
+		return role
+	}
+	if userID == chat.CreatorId && len(owners(chat)) == 0 {
+		return pb.Role_OWNER
+	}
+	return pb.Role_MEMBER
+}
+
+// canManage returns true if the user is an owner or admin of the chat
+func canManage(chat *pb.ChatInfo, userID string) bool {
+	role := roleOf(chat, userID)
+	return role == pb.Role_OWNER || role == pb.Role_ADMIN
+}
+
+// rank orders the roles by the permissions they grant, owners rank highest
+func rank(role pb.Role) int {
+	switch role {
+	case pb.Role_OWNER:
+		return 3
+	case pb.Role_ADMIN:
+		return 2
+	case pb.Role_MEMBER:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// owners returns the users the chat records as its owners
+func owners(chat *pb.ChatInfo) []string {
+	var ids []string
+	for _, userID := range chat.UserIds {
+		if chat.Roles[userID] == pb.Role_OWNER {
+			ids = append(ids, userID)
+		}
+	}
+	return ids
+}
+
+// setRole sets the role of the user in the chat. The owner of a chat created before roles were
+// introduced is recorded first, so changing the roles doesn't change who owns it.
+func setRole(chat *pb.ChatInfo, userID string, role pb.Role) {
+	if chat.Roles == nil {
+		chat.Roles = make(map[string]pb.Role)
+	}
+	if len(owners(chat)) == 0 && containsString(chat.UserIds, chat.CreatorId) {
+		chat.Roles[chat.CreatorId] = pb.Role_OWNER
+	}
+	if role == pb.Role_MEMBER {
+		delete(chat.Roles, userID)
+		return
+	}
+	chat.Roles[userID] = role
+}
+
+// successor returns the user who becomes the owner of the chat when its last owner leaves. The first
+// admin is chosen, otherwise the first member and then the first read only user.
+func successor(chat *pb.ChatInfo, ownerID string) string {
+	var next string
+	for _, userID := range chat.UserIds {
+		if userID == ownerID {
+			continue
+		}
+		if len(next) == 0 || rank(roleOf(chat, userID)) > rank(roleOf(chat, next)) {
+			next = userID
+		}
+	}
+	return next
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/micro-community/micro-chat/model"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/store"
+)
+
+// messages are given consecutive sequences within their chat as they're saved, so sessions can tell
+// whether they've missed a message and resume from exactly where the client left off. The sequence is
+// allocated and the message saved while holding the lock of the chat's sequence, so a message is
+// always saved before the next one is given its sequence. The time a message was sent is allocated
+// along with it and always increases, so the messages are ordered the same way by both.
+
+// sequence is the last sequence allocated in a chat, along with the time the message was sent
+type sequence struct {
+	Sequence int64 `json:"sequence"`
+	SentAt   int64 `json:"sent_at"`
+}
+
+// saveMessage allocates the message the next sequence of its chat and saves it. If the message was
+// already saved by a previous attempt it keeps the sequence it was given, since clients may have
+// received it. If saving fails after the sequence is allocated the sequence is left unused, the retry
+// is given a new one.
+func (c *Chat) saveMessage(msg *pb.Message) error {
+	unlock, err := c.acquireLock(sequenceStoreKeyPrefix + msg.ChatId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if saved, err := c.repo.Read(msg.Id); err == nil {
+		msg.Sequence = saved.Sequence
+		msg.SentAt = saved.SentAt
+		return nil
+	} else if err != model.ErrNotFound {
+		return err
+	}
+
+	last, err := c.readSequence(msg.ChatId)
+	if err != nil {
+		return err
+	}
+
+	// stamp the message with the time it was received, the clients clock can't be trusted so the
+	// time it claims to have sent the message is only kept for diagnostics. The clocks of the instances
+	// of the service can differ, so it's always after the previous message.
+	msg.Sequence = last.Sequence + 1
+	msg.SentAt = unixMillis(time.Now())
+	if msg.SentAt <= last.SentAt {
+		msg.SentAt = last.SentAt + 1
+	}
+	bytes, err := json.Marshal(&sequence{Sequence: msg.Sequence, SentAt: msg.SentAt})
+	if err != nil {
+		return err
+	}
+	if err := store.Write(&store.Record{Key: sequenceStoreKeyPrefix + msg.ChatId, Value: bytes}); err != nil {
+		return err
+	}
+	if err := c.repo.Create(msg); err != nil {
+		return err
+	}
+
+	// replies are counted once they're saved, a retry of a reply which was already saved isn't counted
+	// again
+	if len(msg.ThreadRootId) > 0 {
+		return c.countReply(msg.ThreadRootId, 1)
+	}
+	return nil
+}
+
+// readSequence returns the last sequence allocated in the chat. Chats whose messages were sent before
+// sequences were introduced start from zero, after the time their last message was sent.
+func (c *Chat) readSequence(chatID string) (*sequence, error) {
+	recs, err := store.Read(sequenceStoreKeyPrefix + chatID)
+	if err == nil {
+		var seq sequence
+		if err := json.Unmarshal(recs[0].Value, &seq); err != nil {
+			return nil, err
+		}
+		return &seq, nil
+	} else if err != store.ErrNotFound {
+		return nil, err
+	}
+
+	last, err := c.lastMessage(chatID)
+	if err != nil || last == nil {
+		return &sequence{}, err
+	}
+	return &sequence{SentAt: last.SentAt}, nil
+}
+
+// readSequences returns the messages of the chat with a sequence in [from, to), ordered by their
+// sequence. The sequences of the messages which failed to save are missing.
+func (c *Chat) readSequences(chatID string, from, to int64) ([]*pb.Message, error) {
+	if to <= from {
+		return nil, nil
+	}
+	pos, err := searchPosition(c.messageAt(chatID), func(msg *pb.Message) bool { return msg.Sequence >= from })
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := c.repo.ListByChat(chatID, to-from, int64(pos))
+	if err != nil {
+		return nil, err
+	}
+	var result []*pb.Message
+	for _, msg := range msgs {
+		if msg.Sequence >= from && msg.Sequence < to {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
+
+// sequencer orders the messages delivered to a session by their sequence. The event stream doesn't
+// deliver the messages in the order they were published, so the messages received ahead of the next
+// one are held until the ones before them have been delivered, which are read from the repository.
+// The client can then resume from the last message it received without missing any.
+type sequencer struct {
+	// next is the sequence of the next message to deliver
+	next int64
+	// pending holds the messages received ahead of the next one, keyed by their sequence. The messages
+	// which aren't sent to the client are held as nil, so they're still counted as received.
+	pending map[int64]*pb.Message
+	// replayed records the ids of the messages without a sequence which have been delivered
+	replayed map[string]bool
+}
+
+// newSequencer returns a sequencer which delivers the messages from the sequence onwards
+func newSequencer(next int64) *sequencer {
+	return &sequencer{next: next, pending: make(map[int64]*pb.Message), replayed: make(map[string]bool)}
+}
+
+// add a message received, returning the messages which can now be delivered in order. A skipped
+// message is received but not returned, such as the messages sent from the device of the session. If
+// messages before the ones held haven't been received, the sequence up to which they're missing is
+// returned and they must be read from the repository and passed to fill. Messages already delivered
+// are dropped.
+func (q *sequencer) add(msg *pb.Message, skip bool) ([]*pb.Message, int64) {
+	// messages sent before sequences were introduced are delivered as they're received
+	if msg.Sequence == 0 {
+		if q.replayed[msg.Id] {
+			return nil, 0
+		}
+		q.replayed[msg.Id] = true
+		if skip {
+			return nil, 0
+		}
+		return []*pb.Message{msg}, 0
+	}
+	if msg.Sequence < q.next {
+		return nil, 0
+	}
+	if skip {
+		q.pending[msg.Sequence] = nil
+	} else {
+		q.pending[msg.Sequence] = msg
+	}
+	ready := q.drain()
+
+	var missingTo int64
+	for seq := range q.pending {
+		if missingTo == 0 || seq < missingTo {
+			missingTo = seq
+		}
+	}
+	return ready, missingTo
+}
+
+// fill in the messages with a sequence in [next, to) read from the repository, returning the messages
+// which can now be delivered in order. The sequences missing from the messages were never used, so
+// they're skipped.
+func (q *sequencer) fill(msgs []*pb.Message, to int64) []*pb.Message {
+	var ready []*pb.Message
+	for _, msg := range msgs {
+		if msg.Sequence == 0 {
+			if !q.replayed[msg.Id] {
+				q.replayed[msg.Id] = true
+				ready = append(ready, msg)
+			}
+		} else if _, ok := q.pending[msg.Sequence]; !ok && msg.Sequence >= q.next {
+			q.pending[msg.Sequence] = msg
+		}
+	}
+	for ; q.next < to; q.next++ {
+		if msg, ok := q.pending[q.next]; ok {
+			if msg != nil {
+				ready = append(ready, msg)
+			}
+			delete(q.pending, q.next)
+		}
+	}
+	return append(ready, q.drain()...)
+}
+
+// drain returns the messages held which follow on from the last one delivered
+func (q *sequencer) drain() []*pb.Message {
+	var ready []*pb.Message
+	for {
+		msg, ok := q.pending[q.next]
+		if !ok {
+			return ready
+		}
+		if msg != nil {
+			ready = append(ready, msg)
+		}
+		delete(q.pending, q.next)
+		q.next++
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	pb "github.com/micro-community/micro-chat/proto"
+)
+
+// sequenced returns a message with the sequence, its id is the sequence
+func sequenced(seq int64) *pb.Message {
+	return &pb.Message{Id: fmt.Sprintf("m%d", seq), Sequence: seq}
+}
+
+// sequences returns the sequences of the messages
+func sequences(msgs []*pb.Message) []int64 {
+	var seqs []int64
+	for _, msg := range msgs {
+		seqs = append(seqs, msg.Sequence)
+	}
+	return seqs
+}
+
+func TestSequencerAdd(t *testing.T) {
+	q := newSequencer(3)
+
+	// messages before the first one delivered are dropped
+	if ready, missingTo := q.add(sequenced(2), false); ready != nil || missingTo != 0 {
+		t.Errorf("add(2) = %v, %v, want it dropped", sequences(ready), missingTo)
+	}
+	if ready, missingTo := q.add(sequenced(3), false); !reflect.DeepEqual(sequences(ready), []int64{3}) || missingTo != 0 {
+		t.Errorf("add(3) = %v, %v, want [3], 0", sequences(ready), missingTo)
+	}
+
+	// messages received ahead of the next one are held until it's received
+	if ready, missingTo := q.add(sequenced(6), false); ready != nil || missingTo != 6 {
+		t.Errorf("add(6) = %v, %v, want none missing to 6", sequences(ready), missingTo)
+	}
+	if ready, missingTo := q.add(sequenced(5), true); ready != nil || missingTo != 5 {
+		t.Errorf("add(5) = %v, %v, want none missing to 5", sequences(ready), missingTo)
+	}
+	if ready, missingTo := q.add(sequenced(4), false); !reflect.DeepEqual(sequences(ready), []int64{4, 6}) || missingTo != 0 {
+		t.Errorf("add(4) = %v, %v, want [4 6] with the skipped message dropped", sequences(ready), missingTo)
+	}
+
+	// messages already delivered are dropped
+	if ready, _ := q.add(sequenced(6), false); ready != nil {
+		t.Errorf("add(6) again = %v, want it dropped", sequences(ready))
+	}
+}
+
+func TestSequencerFill(t *testing.T) {
+	q := newSequencer(1)
+	q.add(sequenced(5), false)
+
+	// the messages read from the repository are delivered in order, skipping the unused sequences and
+	// the messages already held
+	ready := q.fill([]*pb.Message{sequenced(1), sequenced(2), sequenced(4), sequenced(5)}, 5)
+	if !reflect.DeepEqual(sequences(ready), []int64{1, 2, 4, 5}) {
+		t.Errorf("fill() = %v, want [1 2 4 5]", sequences(ready))
+	}
+	if q.next != 6 {
+		t.Errorf("fill() next = %v, want 6", q.next)
+	}
+}
+
+func TestSequencerLegacy(t *testing.T) {
+	q := newSequencer(1)
+	legacy := &pb.Message{Id: "legacy"}
+
+	// messages without a sequence are delivered once, before the messages with one
+	ready := q.fill([]*pb.Message{legacy, sequenced(1)}, 2)
+	if len(ready) != 2 || ready[0] != legacy || ready[1].Sequence != 1 {
+		t.Errorf("fill() = %v, want the legacy message then 1", ready)
+	}
+	if ready, _ := q.add(legacy, false); ready != nil {
+		t.Errorf("add() of a replayed legacy message = %v, want it dropped", ready)
+	}
+}
@@ -0,0 +1,594 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/micro-community/micro-chat/model"
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/context/metadata"
+	"github.com/micro/micro/v3/service/errors"
+	"github.com/micro/micro/v3/service/events"
+	"github.com/micro/micro/v3/service/logger"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// connection is a stream opened by a client using Connect or ConnectV2. Connect only carries messages,
+// so its connection drops any other kind of event sent to the client.
+type connection interface {
+	// Send an event to the client
+	Send(*pb.ChatEvent) error
+	// Recv an event from the client
+	Recv() (*pb.ChatEvent, error)
+}
+
+// session is a user connected to a chat. Events are sent to the client from several goroutines, so
+// sending is serialised.
+type session struct {
+	id     string
+	chatID string
+	userID string
+	// sessionID uniquely identifies the connection
+	sessionID string
+	// deviceID identifies the device of the user, it's blank if the client didn't identify it
+	deviceID string
+	// lastMessageID is the last message the client received before it reconnected, the messages sent
+	// since are replayed before switching to live delivery
+	lastMessageID string
+
+	sync.Mutex
+	conn connection
+
+	// typing expires the typing indicator of the user if it isn't renewed or stopped, it's nil if the
+	// user hasn't started typing. It's only accessed by the goroutine processing the client events.
+	typing *time.Timer
+}
+
+// send an event to the client
+func (s *session) send(ev *pb.ChatEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.conn.Send(ev)
+}
+
+// sendError sends an error to the client, to let them know an event they sent couldn't be processed
+func (s *session) sendError(clientID string, err error) error {
+	merr := errors.FromError(err)
+	return s.send(&pb.ChatEvent{
+		ChatId:    s.chatID,
+		Timestamp: unixMillis(time.Now()),
+		Event: &pb.ChatEvent_Error{Error: &pb.EventError{
+			ClientId: clientID, Id: merr.Id, Detail: merr.Detail, Code: merr.Code,
+		}},
+	})
+}
+
+// connect validates the request to connect to a chat and returns the session, the caller sets the
+// connection. The id passed is used as the prefix of the errors returned, e.g. "chat.Connect".
+func connect(ctx context.Context, id string) (*session, error) {
+	// the client passed the chat id and user id in the request context. we'll load that information
+	// now and validate it. The user id is checked against the authenticated account, and if any
+	// information is missing we'll return a BadRequest error to the client
+	claimedID, _ := metadata.Get(ctx, "user-id")
+	userID, err := identify(ctx, id, claimedID)
+	if err != nil {
+		return nil, err
+	}
+	if len(userID) == 0 {
+		return nil, errors.BadRequest(id+".MissingUserID", "UserID missing in context")
+	}
+	chatID, ok := metadata.Get(ctx, "chat-id")
+	if !ok {
+		return nil, errors.BadRequest(id+".MissingChatID", "ChatId missing in context")
+	}
+
+	// lookup the chat from the store to ensure it's valid, and as per the New function authorize the
+	// request to ensure the user is part of the chat they're attempting to connect to
+	if _, err := authorize(id, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	// clients reconnecting pass the last message they received, so the messages they missed can be
+	// replayed. Clients can also identify the device they're running on, so the messages the user
+	// sends from their other devices are sent to them.
+	lastMessageID, _ := metadata.Get(ctx, "last-message-id")
+	deviceID, _ := metadata.Get(ctx, "device-id")
+	return &session{
+		id:            id,
+		chatID:        chatID,
+		userID:        userID,
+		sessionID:     uuid.New().String(),
+		deviceID:      deviceID,
+		lastMessageID: lastMessageID,
+	}, nil
+}
+
+// serve the session until the client disconnects or an error occurs. Events published to the chat
+// are sent to the client, and the events sent by the client are processed.
+func (c *Chat) serve(ctx context.Context, s *session) error {
+	// create a new context which can be cancelled, in the case either the consumer of publisher errors
+	// we don't want one to keep running in the background
+	cancelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// create a channel to send errors on, because the subscriber / publisher will run in separate go-
+	// routines, they need a way of returning errors to the client. It's buffered so the goroutines
+	// don't block once the session has ended.
+	errChan := make(chan error, 3)
+
+	// find where the messages delivered to the client start from. This is done before subscribing, so
+	// every message sent after it is either consumed from the event stream or replayed.
+	last, q, err := c.resumeFrom(s)
+	if err != nil {
+		return err
+	}
+
+	// create an event stream to consume messages posted by other users into the chat. Each session
+	// uses its own queue, scoped to the user, so every device the user is connected from receives
+	// every message. The queue is new, so it's consumed from the time the client connected rather than
+	// from the start of the stream. The clocks of the instances publishing the messages can be behind,
+	// so it starts a little earlier and the messages already delivered are dropped by their sequence.
+	group := s.userID + "/" + s.sessionID
+	connectedAt := time.Now()
+	offset := events.WithOffset(connectedAt.Add(-clockSkew))
+	evStream, err := events.Consume(chatEventKeyPrefix+s.chatID, events.WithGroup(group), offset)
+	if err != nil {
+		logger.Errorf("Error streaming events. Chat ID: %v. Error: %v", s.chatID, err)
+		return errors.InternalServerError(s.id+".Unknown", "Error connecting to the event stream")
+	}
+
+	// replay the messages the client missed while it was disconnected, along with the messages sent
+	// while subscribing. The messages replayed which are also consumed from the event stream are
+	// dropped.
+	if err := c.replay(s, last, q); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			select {
+			case <-cancelCtx.Done():
+				// the context has been cancelled or timed out, stop subscribing to new messages
+				return
+			case ev, ok := <-evStream:
+				if !ok {
+					errChan <- errors.InternalServerError(s.id+".Unknown", "Event stream closed")
+					return
+				}
+
+				// received an event, decode it. if an error occurs log it and cancel the context
+				chatEv, err := decodeEvent(&ev)
+				if err != nil {
+					logger.Errorf("Error unmarshaling event. ChatID: %v. Error: %v", s.chatID, err)
+					errChan <- err
+					return
+				}
+
+				// not every implementation of the event stream supports offsets, so the events published
+				// before the client connected are skipped, the client loads them from the history. The
+				// messages with a sequence are dropped by the sequencer instead.
+				msg := chatEv.GetMessage()
+				if (msg == nil || msg.Sequence == 0) && ev.Timestamp.Before(connectedAt) {
+					continue
+				}
+
+				// messages are delivered in the order they were sent, skipping those sent from this device
+				if msg != nil {
+					if err := c.receive(s, q, msg, s.sentFrom(msg, ev.Metadata[eventDeviceKey])); err != nil {
+						errChan <- err
+						return
+					}
+					continue
+				}
+
+				// publish the event to the stream
+				if err := s.send(chatEv); err != nil {
+					logger.Errorf("Error sending event to stream. ChatID: %v. Event ID: %v. Error: %v", s.chatID, ev.ID, err)
+					errChan <- err
+					return
+				}
+
+				// the session ends once the user is no longer part of the chat
+				if left := chatEv.GetMemberLeft(); left != nil && containsString(left.UserIds, s.userID) {
+					errChan <- errors.Forbidden(s.id+".Forbidden", "No longer a member of this chat")
+					return
+				}
+			}
+		}
+	}()
+
+	// subscribe to the signals sent to the users connected to the chat, such as typing indicators.
+	// Unlike the events they're not persisted, so they're only received while connected.
+	sub, err := subscribeSignals(s.chatID, func(ev *pb.ChatEvent) error {
+		// the users own signals aren't sent back to them
+		if signalUserID(ev) == s.userID {
+			return nil
+		}
+		if err := s.send(ev); err != nil {
+			select {
+			case errChan <- err:
+			default:
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("Error subscribing to signals. Chat ID: %v. Error: %v", s.chatID, err)
+		return errors.InternalServerError(s.id+".Unknown", "Error connecting to the broker")
+	}
+	defer sub.Unsubscribe()
+
+	// the user stops typing when they disconnect
+	defer s.stopTyping()
+
+	// record the user as online until the session ends
+	go s.trackPresence(cancelCtx)
+
+	// transform the stream.Recv into a channel which can be used in the select statement below
+	evChan := make(chan *pb.ChatEvent)
+	go func() {
+		for {
+			ev, err := s.conn.Recv()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			select {
+			case evChan <- ev:
+			case <-cancelCtx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-cancelCtx.Done():
+			// the context has been cancelled or timed out, stop subscribing to new messages
+			return nil
+		case err := <-errChan:
+			// an error occurred in another goroutine, terminate the stream
+			return err
+		case ev := <-evChan:
+			if err := c.handleEvent(s, ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sentFrom returns true if the message was sent by this device. Clients which don't identify their
+// device are treated as if they sent every message of the user, so they never receive them.
+func (s *session) sentFrom(msg *pb.Message, deviceID string) bool {
+	if msg.UserId != s.userID {
+		return false
+	}
+	return len(s.deviceID) == 0 || s.deviceID == deviceID
+}
+
+// resumeFrom returns the last message the client received before it reconnected, and the sequencer
+// which delivers the messages sent after it. Clients which aren't resuming receive the messages sent
+// from now on, so nil is returned for the last message.
+func (c *Chat) resumeFrom(s *session) (*pb.Message, *sequencer, error) {
+	if len(s.lastMessageID) == 0 {
+		seq, err := c.readSequence(s.chatID)
+		if err != nil {
+			logger.Errorf("Error reading sequence. Chat ID: %v. Error: %v", s.chatID, err)
+			return nil, nil, errors.InternalServerError(s.id+".Unknown", "Error reading the messages")
+		}
+		return nil, newSequencer(seq.Sequence + 1), nil
+	}
+
+	// lookup the last message received, it must be in the chat being connected to
+	last, err := c.repo.Read(s.lastMessageID)
+	if err == model.ErrNotFound || (err == nil && last.ChatId != s.chatID) {
+		return nil, nil, errors.BadRequest(s.id+".InvalidLastMessageID", "Message not found with this ID")
+	} else if err != nil {
+		logger.Errorf("Error reading message. Message ID: %v. Error: %v", s.lastMessageID, err)
+		return nil, nil, errors.InternalServerError(s.id+".Unknown", "Error reading the message")
+	}
+
+	// messages sent before sequences were introduced have none, every message with a sequence was sent
+	// after them
+	return last, newSequencer(last.Sequence + 1), nil
+}
+
+// replay sends the messages sent to the chat after the last message the client received, up to the
+// last one sent. The messages are read from the repository since the event store doesn't return
+// events in the order they were published. Edits and deletes of the messages sent before the last one
+// received aren't replayed, clients reload the history if they need them.
+func (c *Chat) replay(s *session, last *pb.Message, q *sequencer) error {
+	seq, err := c.readSequence(s.chatID)
+	if err != nil {
+		logger.Errorf("Error reading sequence. Chat ID: %v. Error: %v", s.chatID, err)
+		return errors.InternalServerError(s.id+".Unknown", "Error reading the messages")
+	}
+
+	var msgs []*pb.Message
+	if last != nil && last.Sequence == 0 {
+		// the last message received has no sequence, so the messages after it are found by their
+		// position in the ordered index
+		after, err := searchPosition(c.messageAt(s.chatID), func(msg *pb.Message) bool {
+			return positionBefore(last.SentAt, last.Id, msg.SentAt, msg.Id)
+		})
+		if err == nil {
+			msgs, err = c.repo.ListByChat(s.chatID, 0, int64(after))
+		}
+		if err != nil {
+			logger.Errorf("Error reading messages. Chat ID: %v. Error: %v", s.chatID, err)
+			return errors.InternalServerError(s.id+".Unknown", "Error reading the messages")
+		}
+	} else if msgs, err = c.readSequences(s.chatID, q.next, seq.Sequence+1); err != nil {
+		logger.Errorf("Error reading messages. Chat ID: %v. Error: %v", s.chatID, err)
+		return errors.InternalServerError(s.id+".Unknown", "Error reading the messages")
+	}
+
+	for _, msg := range q.fill(msgs, seq.Sequence+1) {
+		if err := s.deliver(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive delivers a message consumed from the event stream, once the messages sent before it have
+// been delivered. The messages before it which haven't been consumed are read from the repository,
+// the sequence of each message is saved before the next one is allocated so they're never missed.
+func (c *Chat) receive(s *session, q *sequencer, msg *pb.Message, skip bool) error {
+	ready, missingTo := q.add(msg, skip)
+	if missingTo > 0 {
+		msgs, err := c.readSequences(s.chatID, q.next, missingTo)
+		if err != nil {
+			logger.Errorf("Error reading messages. Chat ID: %v. Error: %v", s.chatID, err)
+			return err
+		}
+		ready = append(ready, q.fill(msgs, missingTo)...)
+	}
+	for _, msg := range ready {
+		if err := s.deliver(msg); err != nil {
+			logger.Errorf("Error sending event to stream. ChatID: %v. Message ID: %v. Error: %v", s.chatID, msg.Id, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends a message to the client. The messages deleted since they were sent are delivered as a
+// delete. The device the messages read from the repository were sent from isn't recorded, so the
+// users own messages are only delivered to clients which identify their device and are expected to
+// discard the ones they sent.
+func (s *session) deliver(msg *pb.Message) error {
+	if s.sentFrom(msg, "") {
+		return nil
+	}
+	ev := &pb.ChatEvent{ChatId: s.chatID, Timestamp: msg.SentAt, Event: &pb.ChatEvent_Message{Message: msg}}
+	if msg.Deleted {
+		ev.Event = &pb.ChatEvent_Delete{Delete: msg}
+	}
+	return s.send(ev)
+}
+
+// handleEvent processes an event sent by the client. Errors processing the event are sent back to the
+// client rather than returned, so a single bad event doesn't end the session. Only errors sending to
+// the client are returned.
+func (c *Chat) handleEvent(s *session, ev *pb.ChatEvent) error {
+	switch e := ev.Event.(type) {
+	case *pb.ChatEvent_Message:
+		// only the fields the client is allowed to set are copied, the rest are set by the server so
+		// clients can't forge system messages or deletions. The time the client claims to have sent the
+		// message is kept for diagnostics, older clients set it as the time the message was sent.
+		msg := &pb.Message{
+			ClientId:     e.Message.ClientId,
+			ChatId:       s.chatID,
+			UserId:       s.userID,
+			Subject:      e.Message.Subject,
+			Text:         e.Message.Text,
+			ClientSentAt: e.Message.ClientSentAt,
+			ReplyToId:    e.Message.ReplyToId,
+		}
+		if msg.ClientSentAt == 0 {
+			msg.ClientSentAt = e.Message.SentAt
+		}
+
+		// set the defaults. the client id is defaulted here rather than by createMessage so the error
+		// sent to the client can always reference it
+		if len(msg.ClientId) == 0 {
+			msg.ClientId = uuid.New().String()
+		}
+
+		// validate the message
+		if len(msg.Text) == 0 {
+			return s.sendError(msg.ClientId, errors.BadRequest(s.id+".MissingText", "Text is missing"))
+		}
+
+		// the role of the user can change while they're connected, so it's checked for each message
+		chat, err := authorize(s.id, s.chatID, s.userID)
+		if err != nil {
+			return s.sendError(msg.ClientId, err)
+		}
+		if roleOf(chat, s.userID) == pb.Role_READ_ONLY {
+			return s.sendError(msg.ClientId, errors.Forbidden(s.id+".ReadOnly", "Read only users can't send messages"))
+		}
+
+		// a reply is added to the thread of the message it replies to
+		if err := c.resolveReply(s.id, msg); err != nil {
+			return s.sendError(msg.ClientId, err)
+		}
+
+		// create the message. the id of the error matches the one returned by Send, so clients can
+		// retry messages which failed with an InternalServerError using the same client id
+		clientID := msg.ClientId
+		msg, duplicate, err := c.createMessage(msg, s.deviceID)
+		if err != nil {
+			logger.Errorf("Error creating message. Chat ID: %v. Error: %v", s.chatID, err)
+			return s.sendError(clientID, errors.InternalServerError(s.id+".Unknown", "Error creating the message"))
+		}
+
+		// sending a message stops the user typing
+		s.stopTyping()
+
+		// acknowledge the message, so the client knows the id allocated to it and can stop retrying
+		return s.send(&pb.ChatEvent{
+			ChatId:    s.chatID,
+			Timestamp: unixMillis(time.Now()),
+			Event: &pb.ChatEvent_Ack{Ack: &pb.Ack{
+				ClientId: msg.ClientId, MessageId: msg.Id, SentAt: msg.SentAt, Duplicate: duplicate,
+			}},
+		})
+	case *pb.ChatEvent_ReadReceipt:
+		if _, err := c.markRead(s.id, s.chatID, s.userID, e.ReadReceipt.MessageId); err != nil {
+			return s.sendError("", err)
+		}
+		return nil
+	case *pb.ChatEvent_Typing:
+		if e.Typing.Typing {
+			s.startTyping()
+		} else {
+			s.stopTyping()
+		}
+		return nil
+	default:
+		// the other events are sent by the server
+		return s.sendError("", errors.BadRequest(s.id+".UnsupportedEvent", "Clients can't send this event"))
+	}
+}
+
+// trackPresence records the user as connected to the chat until the context is cancelled, and lets
+// the other users know when the user comes online or goes offline. A user is online as long as they
+// have at least one session. Presence is best effort, so errors are only logged.
+func (s *session) trackPresence(ctx context.Context) {
+	online, err := isOnline(s.chatID, s.userID)
+	if err != nil {
+		logger.Errorf("Error reading presence. Chat ID: %v. User ID: %v. Error: %v", s.chatID, s.userID, err)
+	}
+	if err := writeSession(s.chatID, s.userID, s.sessionID); err != nil {
+		logger.Errorf("Error writing session. Chat ID: %v. User ID: %v. Error: %v", s.chatID, s.userID, err)
+	}
+	if !online {
+		s.publishPresence(&pb.Presence{UserId: s.userID, Online: true, LastSeenAt: unixMillis(time.Now())})
+	}
+
+	// renew the session while it's open. The session is only deleted after the last renewal, so a
+	// renewal can't record the user as online after they disconnect.
+	ticker := time.NewTicker(presenceTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := writeSession(s.chatID, s.userID, s.sessionID); err != nil {
+				logger.Errorf("Error writing session. Chat ID: %v. User ID: %v. Error: %v", s.chatID, s.userID, err)
+			}
+		case <-ctx.Done():
+			s.disconnect()
+			return
+		}
+	}
+}
+
+// disconnect records the user as disconnected from the chat, and if this was their last session lets
+// the other users know they've gone offline
+func (s *session) disconnect() {
+	lastSeen := unixMillis(time.Now())
+	if err := deleteSession(s.chatID, s.userID, s.sessionID); err != nil {
+		logger.Errorf("Error deleting session. Chat ID: %v. User ID: %v. Error: %v", s.chatID, s.userID, err)
+	}
+	if err := writeLastSeen(s.userID, lastSeen); err != nil {
+		logger.Errorf("Error writing last seen. User ID: %v. Error: %v", s.userID, err)
+	}
+	online, err := isOnline(s.chatID, s.userID)
+	if err != nil {
+		logger.Errorf("Error reading presence. Chat ID: %v. User ID: %v. Error: %v", s.chatID, s.userID, err)
+		return
+	}
+	if !online {
+		s.publishPresence(&pb.Presence{UserId: s.userID, Online: false, LastSeenAt: lastSeen})
+	}
+}
+
+// publishPresence publishes the presence of the user to the other users connected to the chat
+func (s *session) publishPresence(p *pb.Presence) {
+	ev := &pb.ChatEvent{ChatId: s.chatID, Event: &pb.ChatEvent_Presence{Presence: p}}
+	if err := publishSignal(ev); err != nil {
+		logger.Errorf("Error publishing presence. Chat ID: %v. Error: %v", s.chatID, err)
+	}
+}
+
+// startTyping lets the other users know the user is typing. Clients renew the indicator while the
+// user keeps typing, if it isn't renewed within typingTTL it's stopped.
+func (s *session) startTyping() {
+	if s.typing != nil {
+		s.typing.Stop()
+	}
+	s.typing = time.AfterFunc(typingTTL, func() { s.publishTyping(false) })
+	s.publishTyping(true)
+}
+
+// stopTyping lets the other users know the user stopped typing, if they were typing
+func (s *session) stopTyping() {
+	if s.typing == nil {
+		return
+	}
+	// if the timer had already fired the indicator has expired, and the stop has been published
+	if s.typing.Stop() {
+		s.publishTyping(false)
+	}
+	s.typing = nil
+}
+
+// publishTyping publishes the typing indicator of the user. Typing indicators are best effort, so
+// errors are only logged.
+func (s *session) publishTyping(typing bool) {
+	t := &pb.Typing{UserId: s.userID, Typing: typing}
+	if typing {
+		t.ExpiresAt = unixMillis(time.Now().Add(typingTTL))
+	}
+	ev := &pb.ChatEvent{ChatId: s.chatID, Event: &pb.ChatEvent_Typing{Typing: t}}
+	if err := publishSignal(ev); err != nil {
+		logger.Errorf("Error publishing typing indicator. Chat ID: %v. Error: %v", s.chatID, err)
+	}
+}
+
+// decodeEvent decodes an event consumed from the event stream of a chat. Messages, edits and deletes
+// are published as the message itself, the other events are published as a chat event.
+func decodeEvent(ev *events.Event) (*pb.ChatEvent, error) {
+	eventType := ev.Metadata[eventTypeKey]
+	if eventType == eventTypeEvent {
+		var chatEv pb.ChatEvent
+		if err := protojson.Unmarshal(ev.Payload, &chatEv); err != nil {
+			return nil, err
+		}
+		return &chatEv, nil
+	}
+
+	var msg pb.Message
+	if err := ev.Unmarshal(&msg); err != nil {
+		return nil, err
+	}
+	chatEv := &pb.ChatEvent{ChatId: msg.ChatId, Timestamp: unixMillis(ev.Timestamp)}
+	switch eventType {
+	case eventTypeEdit:
+		chatEv.Event = &pb.ChatEvent_Edit{Edit: &msg}
+	case eventTypeDelete:
+		chatEv.Event = &pb.ChatEvent_Delete{Delete: &msg}
+	default:
+		// messages published before the type of the event was recorded are new messages
+		chatEv.Event = &pb.ChatEvent_Message{Message: &msg}
+	}
+	return chatEv, nil
+}
+
+// publishEvent publishes an event, other than a message, to the event stream of the chat. The event
+// is encoded using protojson since the event stream encodes payloads as plain json, which can't decode
+// the oneof.
+func publishEvent(ev *pb.ChatEvent) error {
+	ev.Timestamp = unixMillis(time.Now())
+	bytes, err := protojson.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return events.Publish(chatEventKeyPrefix+ev.ChatId, bytes, events.WithMetadata(map[string]string{
+		eventTypeKey: eventTypeEvent,
+	}))
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"time"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/broker"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// signals are events which are only of interest to the users connected at the time they're sent, such
+// as a user typing. They're sent using the broker rather than the event stream so they're never
+// persisted and don't appear in the history of the chat.
+
+// publishSignal publishes an event to the users connected to the chat
+func publishSignal(ev *pb.ChatEvent) error {
+	ev.Timestamp = unixMillis(time.Now())
+	bytes, err := protojson.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return broker.Publish(signalTopicPrefix+ev.ChatId, &broker.Message{Body: bytes})
+}
+
+// subscribeSignals subscribes to the events published to the users connected to the chat. No queue is
+// used so every session receives every signal.
+func subscribeSignals(chatID string, fn func(*pb.ChatEvent) error) (broker.Subscriber, error) {
+	return broker.Subscribe(signalTopicPrefix+chatID, func(m *broker.Message) error {
+		var ev pb.ChatEvent
+		if err := protojson.Unmarshal(m.Body, &ev); err != nil {
+			return err
+		}
+		return fn(&ev)
+	})
+}
+
+// signalUserID returns the id of the user who sent the signal
+func signalUserID(ev *pb.ChatEvent) string {
+	switch e := ev.Event.(type) {
+	case *pb.ChatEvent_Typing:
+		return e.Typing.UserId
+	case *pb.ChatEvent_Presence:
+		return e.Presence.UserId
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	pb "github.com/micro-community/micro-chat/proto"
+	"github.com/micro/micro/v3/service/store"
+)
+
+// readChat loads a chat record from the store. If the chat doesn't exist store.ErrNotFound is
+// returned.
+func readChat(chatID string) (*pb.ChatInfo, error) {
+	recs, err := store.Read(chatStoreKeyPrefix + chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// chats created before the record was introduced only stored their id as the value
+	var chat pb.ChatInfo
+	if err := json.Unmarshal(recs[0].Value, &chat); err != nil {
+		return &pb.ChatInfo{Id: chatID}, nil
+	}
+	return &chat, nil
+}
+
+// writeChat writes a chat record to the store
+func writeChat(chat *pb.ChatInfo) error {
+	bytes, err := json.Marshal(chat)
+	if err != nil {
+		return err
+	}
+	return store.Write(&store.Record{Key: chatStoreKeyPrefix + chat.Id, Value: bytes})
+}
+
+// addMember indexes the chat against the user, so the chats a user is part of can be listed
+func addMember(chatID, userID string) error {
+	return store.Write(&store.Record{Key: memberStoreKeyPrefix + userID + "/" + chatID, Value: []byte(chatID)})
+}
+
+// removeMember removes the chat from the chats the user is part of, along with the state the user
+// had in the chat
+func removeMember(chatID, userID string) error {
+	keys := []string{
+		memberStoreKeyPrefix + userID + "/" + chatID,
+		hiddenStoreKeyPrefix + userID + "/" + chatID,
+		readStoreKeyPrefix + chatID + "/" + userID,
+	}
+	for _, key := range keys {
+		if err := deleteKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexParticipants indexes the chat by its users, so all the chats between them can be listed, e.g.
+// "participants/usera-userb-userc/<chat id>". If the users don't have a default chat yet, or their
+// default chat has since been destroyed, this chat becomes their default.
+func indexParticipants(chat *pb.ChatInfo) error {
+	usersKey := participantsKey(chat.UserIds)
+	record := store.Record{Key: participantStoreKeyPrefix + usersKey + "/" + chat.Id, Value: []byte(chat.Id)}
+	if err := store.Write(&record); err != nil {
+		return err
+	}
+	recs, err := store.Read(chatStoreKeyPrefix + usersKey)
+	if err == nil {
+		if _, err = readChat(string(recs[0].Value)); err == nil {
+			return nil
+		}
+	}
+	if err != store.ErrNotFound {
+		return err
+	}
+	return store.Write(&store.Record{Key: chatStoreKeyPrefix + usersKey, Value: []byte(chat.Id)})
+}
+
+// unindexParticipants removes the chat from the index of its users. The default chat for the users is
+// only removed if it's this one, a chat created using forceNew isn't the default.
+func unindexParticipants(chat *pb.ChatInfo) error {
+	usersKey := participantsKey(chat.UserIds)
+	if err := deleteKey(participantStoreKeyPrefix + usersKey + "/" + chat.Id); err != nil {
+		return err
+	}
+	recs, err := store.Read(chatStoreKeyPrefix + usersKey)
+	if err == store.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if string(recs[0].Value) != chat.Id {
+		return nil
+	}
+	return deleteKey(chatStoreKeyPrefix + usersKey)
+}
+
+// hideChat hides the chat from the user, without removing the user from the chat
+func hideChat(chatID, userID string) error {
+	return store.Write(&store.Record{Key: hiddenStoreKeyPrefix + userID + "/" + chatID, Value: []byte(chatID)})
+}
+
+// unhideChat shows a chat hidden using hideChat to the user again
+func unhideChat(chatID, userID string) error {
+	return deleteKey(hiddenStoreKeyPrefix + userID + "/" + chatID)
+}
+
+// isHidden returns true if the chat has been hidden from the user
+func isHidden(chatID, userID string) (bool, error) {
+	_, err := store.Read(hiddenStoreKeyPrefix + userID + "/" + chatID)
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deleteKey deletes a key from the store, a key which doesn't exist isn't treated as an error
+func deleteKey(key string) error {
+	if err := store.Delete(key); err != nil && err != store.ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// writeRevision records the content of a message before it was edited. The keys are ordered by the
+// time the message was edited so the revisions are read back in order.
+func writeRevision(rev *pb.MessageRevision) error {
+	bytes, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%v%v/%020d", revisionStoreKeyPrefix, rev.MessageId, rev.EditedAt)
+	return store.Write(&store.Record{Key: key, Value: bytes})
+}
+
+// readRevisions returns the previous revisions of a message, oldest first
+func readRevisions(messageID string) ([]*pb.MessageRevision, error) {
+	recs, err := store.Read(revisionStoreKeyPrefix+messageID+"/", store.ReadPrefix())
+	if err == store.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	revs := make([]*pb.MessageRevision, len(recs))
+	for i, rec := range recs {
+		var rev pb.MessageRevision
+		if err := json.Unmarshal(rec.Value, &rev); err != nil {
+			return nil, err
+		}
+		revs[i] = &rev
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].EditedAt < revs[j].EditedAt })
+	return revs, nil
+}
+
+// deleteRevisions deletes all the revisions of a message
+func deleteRevisions(messageID string) error {
+	keys, err := store.List(store.ListPrefix(revisionStoreKeyPrefix + messageID + "/"))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := deleteKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLastActivity returns the time the last message was sent to the chat, or zero if no messages
+// have been sent yet
+func readLastActivity(chatID string) (int64, error) {
+	recs, err := store.Read(activityStoreKeyPrefix + chatID)
+	if err == store.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(recs[0].Value), 10, 64)
+}
+
+// writeLastActivity records the time the last message was sent to the chat
+func writeLastActivity(chatID string, ts int64) error {
+	return store.Write(&store.Record{Key: activityStoreKeyPrefix + chatID, Value: []byte(strconv.FormatInt(ts, 10))})
+}
+
+// writeSession records the user as connected to the chat, the record expires unless it's renewed. The
+// session is recorded against the chat and the user, so the presence of the user in a single chat and
+// in any chat can be read.
+func writeSession(chatID, userID, sessionID string) error {
+	keys := []string{
+		sessionStoreKeyPrefix + chatID + "/" + userID + "/" + sessionID,
+		onlineStoreKeyPrefix + userID + "/" + sessionID,
+	}
+	for _, key := range keys {
+		if err := store.Write(&store.Record{Key: key, Value: []byte(sessionID), Expiry: presenceTTL}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteSession records the user as disconnected
+func deleteSession(chatID, userID, sessionID string) error {
+	if err := deleteKey(sessionStoreKeyPrefix + chatID + "/" + userID + "/" + sessionID); err != nil {
+		return err
+	}
+	return deleteKey(onlineStoreKeyPrefix + userID + "/" + sessionID)
+}
+
+// isOnline returns true if the user is connected to the chat. If the chat id is blank it returns true
+// if the user is connected to any chat.
+func isOnline(chatID, userID string) (bool, error) {
+	prefix := onlineStoreKeyPrefix + userID + "/"
+	if len(chatID) > 0 {
+		prefix = sessionStoreKeyPrefix + chatID + "/" + userID + "/"
+	}
+	recs, err := store.Read(prefix, store.ReadPrefix())
+	if err == store.ErrNotFound {
+		return false, nil
+	}
+	return len(recs) > 0, err
+}
+
+// readLastSeen returns the time the user was last connected, or zero if they never have been
+func readLastSeen(userID string) (int64, error) {
+	recs, err := store.Read(lastSeenStoreKeyPrefix + userID)
+	if err == store.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(recs[0].Value), 10, 64)
+}
+
+// writeLastSeen records the time the user was last connected
+func writeLastSeen(userID string, ts int64) error {
+	return store.Write(&store.Record{Key: lastSeenStoreKeyPrefix + userID, Value: []byte(strconv.FormatInt(ts, 10))})
+}
+
+// readCursor is the position a user has read a chat up to
+type readCursor struct {
+	MessageID string `json:"message_id"`
+	// SentAt is the time the message was sent, messages are ordered by the time they were sent and
+	// then their id
+	SentAt int64 `json:"sent_at"`
+	// ReadAt is the time the user read the message
+	ReadAt int64 `json:"read_at"`
+}
+
+// readReadCursor returns the position the user has read the chat up to, or nil if they haven't read
+// any of it
+func readReadCursor(chatID, userID string) (*readCursor, error) {
+	recs, err := store.Read(readStoreKeyPrefix + chatID + "/" + userID)
+	if err == store.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cursor readCursor
+	if err := json.Unmarshal(recs[0].Value, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// writeReadCursor records the position the user has read the chat up to
+func writeReadCursor(chatID, userID string, cursor *readCursor) error {
+	bytes, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	return store.Write(&store.Record{Key: readStoreKeyPrefix + chatID + "/" + userID, Value: bytes})
+}
+
+// unixMillis returns t as a unix timestamp in milliseconds
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
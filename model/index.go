@@ -0,0 +1,420 @@
+package model
+
+import (
+	"container/list"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	pb "github.com/micro-community/micro-chat/proto"
+)
+
+const (
+	// snippetRadius is the number of characters shown either side of the first match in a snippet
+	snippetRadius = 40
+	// highlightStart and highlightEnd wrap the matched terms in a snippet
+	highlightStart = "<em>"
+	highlightEnd   = "</em>"
+)
+
+//SearchIndex is an in memory inverted index of the text and subject of messages. It's local to the
+//instance of the service, the chats are loaded into it from the repository the first time they're
+//searched and the changes to their messages are applied using Update as they're made, including the
+//changes made by other instances. The least recently searched chats are evicted once the index holds
+//more than its maximum number of messages.
+type SearchIndex struct {
+	sync.Mutex
+	// postings maps each term to the ids of the messages containing it
+	postings map[string]map[string]bool
+	// messages indexed by id
+	messages map[string]*pb.Message
+	// chats which have been loaded, or are being loaded, from the repository
+	chats map[string]*indexedChat
+	// recent orders the chats by the time they were last searched, the least recently searched first
+	recent *list.List
+	// maxMessages is the number of messages held before chats are evicted
+	maxMessages int
+}
+
+// indexedChat is the state of a chat in the index
+type indexedChat struct {
+	id string
+	// loaded is false while the messages of the chat are being read from the repository
+	loaded bool
+	// ids of the messages of the chat in the index
+	ids map[string]bool
+	// deleted records the messages which have been deleted, so a change received out of order can't
+	// add them back
+	deleted map[string]bool
+	// pending holds the changes received while the chat is being loaded, which are newer than the
+	// messages read from the repository
+	pending map[string]*pb.Message
+	// elem is the position of the chat in the recent list
+	elem *list.Element
+}
+
+//SearchQuery filters the messages returned by a search
+type SearchQuery struct {
+	// Text the messages must contain, every term must match either the text or the subject
+	Text string
+	// ChatIDs the messages can be in
+	ChatIDs []string
+	// UserID of the sender, optional
+	UserID string
+	// From and To bound the time the messages were sent, zero values are ignored
+	From, To int64
+	// Limit and Offset page the results
+	Limit, Offset int
+}
+
+//SearchResult is a message matching a search along with a snippet of it with the matches highlighted
+type SearchResult struct {
+	Message *pb.Message
+	Snippet string
+}
+
+//NewSearchIndex return an empty index which holds up to maxMessages messages
+func NewSearchIndex(maxMessages int) *SearchIndex {
+	return &SearchIndex{
+		postings:    make(map[string]map[string]bool),
+		messages:    make(map[string]*pb.Message),
+		chats:       make(map[string]*indexedChat),
+		recent:      list.New(),
+		maxMessages: maxMessages,
+	}
+}
+
+//StartLoad records the chat as being searched, and returns true if it hasn't been loaded yet in which
+//case the caller must read its messages from the repository and pass them to Load. The changes made
+//while the messages are being read are kept and applied over them once loaded.
+func (idx *SearchIndex) StartLoad(chatID string) bool {
+	idx.Lock()
+	defer idx.Unlock()
+	chat, ok := idx.chats[chatID]
+	if !ok {
+		chat = &indexedChat{
+			id:      chatID,
+			ids:     make(map[string]bool),
+			deleted: make(map[string]bool),
+			pending: make(map[string]*pb.Message),
+		}
+		chat.elem = idx.recent.PushBack(chat)
+		idx.chats[chatID] = chat
+	} else {
+		idx.recent.MoveToBack(chat.elem)
+	}
+	return !chat.loaded
+}
+
+//Load the messages of a chat read from the repository into the index, after StartLoad. The changes
+//received since StartLoad are newer than the messages read, so they take precedence.
+func (idx *SearchIndex) Load(chatID string, msgs []*pb.Message) {
+	idx.Lock()
+	defer idx.Unlock()
+	chat, ok := idx.chats[chatID]
+	if !ok || chat.loaded {
+		// the chat was evicted or unloaded while it was being read, or another caller loaded it
+		return
+	}
+	for _, msg := range msgs {
+		if _, ok := chat.pending[msg.Id]; !ok {
+			idx.apply(chat, msg)
+		}
+	}
+	for _, msg := range chat.pending {
+		idx.apply(chat, msg)
+	}
+	chat.pending = nil
+	chat.loaded = true
+	idx.evict()
+}
+
+//Update applies a change to a message to the index, deleted messages are removed from it. Changes to
+//the chats which haven't been loaded are ignored, since they're read from the repository when loaded.
+func (idx *SearchIndex) Update(msg *pb.Message) {
+	idx.Lock()
+	defer idx.Unlock()
+	chat, ok := idx.chats[msg.ChatId]
+	if !ok {
+		return
+	}
+	if !chat.loaded {
+		if prev, ok := chat.pending[msg.Id]; !ok || newer(msg, prev) {
+			chat.pending[msg.Id] = msg
+		}
+		return
+	}
+	idx.apply(chat, msg)
+	idx.evict()
+}
+
+//Unload removes a chat and its messages from the index
+func (idx *SearchIndex) Unload(chatID string) {
+	idx.Lock()
+	defer idx.Unlock()
+	if chat, ok := idx.chats[chatID]; ok {
+		idx.unload(chat)
+	}
+}
+
+// apply a change to a message of the chat, unless the message has been deleted or the index already
+// holds a newer edit of it
+func (idx *SearchIndex) apply(chat *indexedChat, msg *pb.Message) {
+	if chat.deleted[msg.Id] {
+		return
+	}
+	if msg.Deleted {
+		chat.deleted[msg.Id] = true
+		delete(chat.ids, msg.Id)
+		idx.remove(msg.Id)
+		return
+	}
+	if prev, ok := idx.messages[msg.Id]; ok && newer(prev, msg) {
+		return
+	}
+	chat.ids[msg.Id] = true
+	idx.add(msg)
+}
+
+// newer returns true if a is a more recent version of the message than b
+func newer(a, b *pb.Message) bool {
+	if a.Deleted != b.Deleted {
+		return a.Deleted
+	}
+	return a.EditedAt > b.EditedAt
+}
+
+// unload removes the chat and its messages
+func (idx *SearchIndex) unload(chat *indexedChat) {
+	for id := range chat.ids {
+		idx.remove(id)
+	}
+	idx.recent.Remove(chat.elem)
+	delete(idx.chats, chat.id)
+}
+
+// evict the least recently searched chats until the index holds at most its maximum number of
+// messages. The most recently searched chat is always kept.
+func (idx *SearchIndex) evict() {
+	for len(idx.messages) > idx.maxMessages && idx.recent.Len() > 1 {
+		idx.unload(idx.recent.Front().Value.(*indexedChat))
+	}
+}
+
+func (idx *SearchIndex) add(msg *pb.Message) {
+	idx.remove(msg.Id)
+	idx.messages[msg.Id] = msg
+	for _, t := range tokenize(msg.Subject + " " + msg.Text) {
+		ids, ok := idx.postings[t.term]
+		if !ok {
+			ids = make(map[string]bool)
+			idx.postings[t.term] = ids
+		}
+		ids[msg.Id] = true
+	}
+}
+
+func (idx *SearchIndex) remove(id string) {
+	msg, ok := idx.messages[id]
+	if !ok {
+		return
+	}
+	delete(idx.messages, id)
+	for _, t := range tokenize(msg.Subject + " " + msg.Text) {
+		delete(idx.postings[t.term], id)
+		if len(idx.postings[t.term]) == 0 {
+			delete(idx.postings, t.term)
+		}
+	}
+}
+
+//Search returns the messages matching the query, the most recently sent first
+func (idx *SearchIndex) Search(q SearchQuery) []*SearchResult {
+	terms := uniqueTerms(q.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+	chats := make(map[string]bool, len(q.ChatIDs))
+	for _, id := range q.ChatIDs {
+		chats[id] = true
+	}
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	// intersect the postings of the terms, starting with the rarest so the candidates stay small
+	sort.Slice(terms, func(i, j int) bool {
+		return len(idx.postings[terms[i]]) < len(idx.postings[terms[j]])
+	})
+	var matches []*pb.Message
+	for id := range idx.postings[terms[0]] {
+		msg := idx.messages[id]
+		if !chats[msg.ChatId] || (len(q.UserID) > 0 && msg.UserId != q.UserID) {
+			continue
+		}
+		if msg.SentAt < q.From || (q.To > 0 && msg.SentAt > q.To) {
+			continue
+		}
+		matchesAll := true
+		for _, t := range terms[1:] {
+			if !idx.postings[t][id] {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
+			matches = append(matches, msg)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].SentAt != matches[j].SentAt {
+			return matches[i].SentAt > matches[j].SentAt
+		}
+		return matches[i].Id < matches[j].Id
+	})
+	if q.Offset >= len(matches) {
+		return nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+
+	results := make([]*SearchResult, len(matches))
+	for i, msg := range matches {
+		results[i] = &SearchResult{Message: msg, Snippet: snippet(msg, terms)}
+	}
+	return results
+}
+
+// token is a term along with its position in the text, in runes
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower case terms. Words are split on anything that isn't a letter or a
+// digit, and characters of scripts which aren't written with spaces between words, such as Chinese,
+// are each treated as a term.
+func tokenize(text string) []token {
+	var tokens []token
+	runes := []rune(text)
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(string(runes[start:end])), start: start, end: end})
+			start = -1
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case isIdeograph(r):
+			flush(i)
+			tokens = append(tokens, token{term: string(r), start: i, end: i + 1})
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(runes))
+	return tokens
+}
+
+// isIdeograph returns true for characters of scripts which aren't written with spaces between words
+func isIdeograph(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// uniqueTerms returns the distinct terms in the text
+func uniqueTerms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range tokenize(text) {
+		if !seen[t.term] {
+			seen[t.term] = true
+			terms = append(terms, t.term)
+		}
+	}
+	return terms
+}
+
+// snippet returns the part of the message around the first match, with the matched terms highlighted.
+// The text is used unless only the subject matches. The snippet is html, so the text is escaped to stop
+// messages injecting markup into the clients which render it.
+func snippet(msg *pb.Message, terms []string) string {
+	match := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		match[t] = true
+	}
+
+	text := msg.Text
+	tokens := tokenize(text)
+	first := firstMatch(tokens, match)
+	if first < 0 {
+		text = msg.Subject
+		tokens = tokenize(text)
+		first = firstMatch(tokens, match)
+	}
+	if first < 0 {
+		return ""
+	}
+
+	runes := []rune(text)
+	from := tokens[first].start - snippetRadius
+	if from < 0 {
+		from = 0
+	}
+	to := tokens[first].end + snippetRadius
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	// find the matches within the snippet, adjacent matches such as the characters of a chinese word
+	// are merged into a single highlight
+	var highlights []token
+	for _, t := range tokens {
+		if t.start < from || t.end > to || !match[t.term] {
+			continue
+		}
+		if n := len(highlights); n > 0 && highlights[n-1].end == t.start {
+			highlights[n-1].end = t.end
+			continue
+		}
+		highlights = append(highlights, t)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("...")
+	}
+	pos := from
+	for _, h := range highlights {
+		b.WriteString(html.EscapeString(string(runes[pos:h.start])))
+		b.WriteString(highlightStart)
+		b.WriteString(html.EscapeString(string(runes[h.start:h.end])))
+		b.WriteString(highlightEnd)
+		pos = h.end
+	}
+	b.WriteString(html.EscapeString(string(runes[pos:to])))
+	if to < len(runes) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// firstMatch returns the position of the first token matching one of the terms, or -1
+func firstMatch(tokens []token, match map[string]bool) int {
+	for i, t := range tokens {
+		if match[t.term] {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,255 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	pb "github.com/micro-community/micro-chat/proto"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+	}{
+		{name: "empty", text: "", terms: nil},
+		{name: "words", text: "Hello, World!", terms: []string{"hello", "world"}},
+		{name: "digits", text: "release v3.0 ships", terms: []string{"release", "v3", "0", "ships"}},
+		{name: "punctuation only", text: "...!?", terms: nil},
+		{name: "chinese", text: "你好世界", terms: []string{"你", "好", "世", "界"}},
+		{name: "mixed", text: "micro微服务", terms: []string{"micro", "微", "服", "务"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var terms []string
+			for _, tok := range tokenize(tt.text) {
+				terms = append(terms, tok.term)
+			}
+			if !reflect.DeepEqual(terms, tt.terms) {
+				t.Errorf("tokenize() = %q, want %q", terms, tt.terms)
+			}
+		})
+	}
+
+	// the positions are in runes, so they can be used to slice the text
+	text := []rune("héllo wörld")
+	for _, tok := range tokenize(string(text)) {
+		if got := string(text[tok.start:tok.end]); got != tok.term {
+			t.Errorf("tokenize() position of %q = %q", tok.term, got)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	// the match is followed and preceded by 60 characters, 40 of which are kept either side
+	long := strings.Repeat("a ", 30) + "needle" + strings.Repeat(" b", 30)
+	tests := []struct {
+		name    string
+		msg     *pb.Message
+		terms   []string
+		snippet string
+	}{
+		{
+			name:    "short text",
+			msg:     &pb.Message{Text: "Hello World"},
+			terms:   []string{"world"},
+			snippet: "Hello <em>World</em>",
+		},
+		{
+			name:    "several matches",
+			msg:     &pb.Message{Text: "ship it, ship it now"},
+			terms:   []string{"ship", "now"},
+			snippet: "<em>ship</em> it, <em>ship</em> it <em>now</em>",
+		},
+		{
+			name:    "subject only",
+			msg:     &pb.Message{Subject: "Release notes", Text: "see attached"},
+			terms:   []string{"release"},
+			snippet: "<em>Release</em> notes",
+		},
+		{
+			name:    "no match",
+			msg:     &pb.Message{Text: "nothing here"},
+			terms:   []string{"missing"},
+			snippet: "",
+		},
+		{
+			name:    "chinese word",
+			msg:     &pb.Message{Text: "我们的微服务"},
+			terms:   []string{"服", "务"},
+			snippet: "我们的微<em>服务</em>",
+		},
+		{
+			name:    "escaped",
+			msg:     &pb.Message{Text: "<script>alert(1)</script> Tom & Jerry"},
+			terms:   []string{"script", "jerry"},
+			snippet: "&lt;<em>script</em>&gt;alert(1)&lt;/<em>script</em>&gt; Tom &amp; <em>Jerry</em>",
+		},
+		{
+			name:    "trimmed",
+			msg:     &pb.Message{Text: long},
+			terms:   []string{"needle"},
+			snippet: "..." + strings.Repeat("a ", 20) + "<em>needle</em>" + strings.Repeat(" b", 20) + "...",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snippet(tt.msg, tt.terms); got != tt.snippet {
+				t.Errorf("snippet() = %q, want %q", got, tt.snippet)
+			}
+		})
+	}
+}
+
+// loadedIndex returns an index with the messages loaded into their chats
+func loadedIndex(msgs ...*pb.Message) *SearchIndex {
+	idx := NewSearchIndex(1000)
+	byChat := make(map[string][]*pb.Message)
+	for _, msg := range msgs {
+		byChat[msg.ChatId] = append(byChat[msg.ChatId], msg)
+	}
+	for chatID, msgs := range byChat {
+		idx.StartLoad(chatID)
+		idx.Load(chatID, msgs)
+	}
+	return idx
+}
+
+// resultIDs returns the ids of the messages in the results
+func resultIDs(results []*SearchResult) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.Message.Id)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	idx := loadedIndex(
+		&pb.Message{Id: "1", ChatId: "a", UserId: "john", Text: "deploy the release", SentAt: 1},
+		&pb.Message{Id: "2", ChatId: "a", UserId: "barry", Text: "release is out", SentAt: 2},
+		&pb.Message{Id: "3", ChatId: "a", UserId: "john", Subject: "Release", Text: "notes attached", SentAt: 3},
+		&pb.Message{Id: "4", ChatId: "b", UserId: "john", Text: "another release", SentAt: 4},
+		&pb.Message{Id: "5", ChatId: "a", UserId: "john", Text: "unrelated", SentAt: 5},
+	)
+	tests := []struct {
+		name string
+		q    SearchQuery
+		ids  []string
+	}{
+		{name: "most recent first", q: SearchQuery{Text: "release", ChatIDs: []string{"a"}}, ids: []string{"3", "2", "1"}},
+		{name: "every term", q: SearchQuery{Text: "release deploy", ChatIDs: []string{"a"}}, ids: []string{"1"}},
+		{name: "case insensitive", q: SearchQuery{Text: "RELEASE Out", ChatIDs: []string{"a"}}, ids: []string{"2"}},
+		{name: "several chats", q: SearchQuery{Text: "release", ChatIDs: []string{"a", "b"}}, ids: []string{"4", "3", "2", "1"}},
+		{name: "other chats excluded", q: SearchQuery{Text: "another", ChatIDs: []string{"a"}}, ids: nil},
+		{name: "sender", q: SearchQuery{Text: "release", ChatIDs: []string{"a"}, UserID: "john"}, ids: []string{"3", "1"}},
+		{name: "time range", q: SearchQuery{Text: "release", ChatIDs: []string{"a"}, From: 2, To: 2}, ids: []string{"2"}},
+		{name: "limit", q: SearchQuery{Text: "release", ChatIDs: []string{"a"}, Limit: 2}, ids: []string{"3", "2"}},
+		{name: "offset", q: SearchQuery{Text: "release", ChatIDs: []string{"a"}, Limit: 2, Offset: 2}, ids: []string{"1"}},
+		{name: "offset past the end", q: SearchQuery{Text: "release", ChatIDs: []string{"a"}, Offset: 5}, ids: nil},
+		{name: "no terms", q: SearchQuery{Text: "!!", ChatIDs: []string{"a"}}, ids: nil},
+		{name: "unknown term", q: SearchQuery{Text: "missing", ChatIDs: []string{"a"}}, ids: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ids := resultIDs(idx.Search(tt.q)); !reflect.DeepEqual(ids, tt.ids) {
+				t.Errorf("Search() = %v, want %v", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestSearchIndexUpdate(t *testing.T) {
+	search := func(idx *SearchIndex, text string) []string {
+		return resultIDs(idx.Search(SearchQuery{Text: text, ChatIDs: []string{"a"}}))
+	}
+
+	t.Run("edit", func(t *testing.T) {
+		idx := loadedIndex(&pb.Message{Id: "1", ChatId: "a", Text: "old text"})
+		idx.Update(&pb.Message{Id: "1", ChatId: "a", Text: "new text", EditedAt: 2})
+		if ids := search(idx, "old"); ids != nil {
+			t.Errorf("Search() of the edited text = %v, want none", ids)
+		}
+		if ids := search(idx, "new"); !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Errorf("Search() of the new text = %v, want [1]", ids)
+		}
+	})
+
+	t.Run("older edit received later", func(t *testing.T) {
+		idx := loadedIndex(&pb.Message{Id: "1", ChatId: "a", Text: "newest", EditedAt: 3})
+		idx.Update(&pb.Message{Id: "1", ChatId: "a", Text: "older", EditedAt: 2})
+		if ids := search(idx, "newest"); !reflect.DeepEqual(ids, []string{"1"}) {
+			t.Errorf("Search() = %v, want [1]", ids)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		idx := loadedIndex(&pb.Message{Id: "1", ChatId: "a", Text: "secret"})
+		idx.Update(&pb.Message{Id: "1", ChatId: "a", Deleted: true})
+		idx.Update(&pb.Message{Id: "1", ChatId: "a", Text: "secret", EditedAt: 5})
+		if ids := search(idx, "secret"); ids != nil {
+			t.Errorf("Search() of a deleted message = %v, want none", ids)
+		}
+	})
+
+	t.Run("deleted while loading", func(t *testing.T) {
+		idx := NewSearchIndex(1000)
+		idx.StartLoad("a")
+		idx.Update(&pb.Message{Id: "1", ChatId: "a", Deleted: true})
+		idx.Load("a", []*pb.Message{{Id: "1", ChatId: "a", Text: "secret"}})
+		if ids := search(idx, "secret"); ids != nil {
+			t.Errorf("Search() of a message deleted while loading = %v, want none", ids)
+		}
+	})
+
+	t.Run("sent while loading", func(t *testing.T) {
+		idx := NewSearchIndex(1000)
+		idx.StartLoad("a")
+		idx.Update(&pb.Message{Id: "2", ChatId: "a", Text: "hello"})
+		idx.Load("a", []*pb.Message{{Id: "1", ChatId: "a", Text: "hello"}})
+		if ids := search(idx, "hello"); len(ids) != 2 {
+			t.Errorf("Search() = %v, want both messages", ids)
+		}
+	})
+
+	t.Run("chat not loaded", func(t *testing.T) {
+		idx := NewSearchIndex(1000)
+		idx.Update(&pb.Message{Id: "1", ChatId: "a", Text: "hello"})
+		if !idx.StartLoad("a") {
+			t.Errorf("StartLoad() = false, want the chat to be loaded")
+		}
+	})
+
+	t.Run("unload", func(t *testing.T) {
+		idx := loadedIndex(&pb.Message{Id: "1", ChatId: "a", Text: "hello"})
+		idx.Unload("a")
+		if ids := search(idx, "hello"); ids != nil {
+			t.Errorf("Search() of an unloaded chat = %v, want none", ids)
+		}
+		if !idx.StartLoad("a") {
+			t.Errorf("StartLoad() = false, want the chat to be loaded again")
+		}
+	})
+}
+
+func TestSearchIndexEvict(t *testing.T) {
+	idx := NewSearchIndex(2)
+	for _, chatID := range []string{"a", "b", "c"} {
+		idx.StartLoad(chatID)
+		idx.Load(chatID, []*pb.Message{{Id: chatID, ChatId: chatID, Text: "hello"}})
+	}
+
+	// the least recently searched chat is evicted once the index holds more than two messages
+	ids := resultIDs(idx.Search(SearchQuery{Text: "hello", ChatIDs: []string{"a", "b", "c"}}))
+	if !reflect.DeepEqual(ids, []string{"b", "c"}) && !reflect.DeepEqual(ids, []string{"c", "b"}) {
+		t.Errorf("Search() = %v, want the messages of b and c", ids)
+	}
+	if !idx.StartLoad("a") {
+		t.Errorf("StartLoad() = false, want the evicted chat to be loaded again")
+	}
+	if idx.StartLoad("b") {
+		t.Errorf("StartLoad() = true, want the chat to still be loaded")
+	}
+}
@@ -3,49 +3,58 @@
  * @Date: 2020-10-30 00:18:11
  * @Last Modified by: none
  * @Last Modified time: 2020-10-30 00:19:28
- * @Description: current model have not been used
+ * @Description: messages are persisted in a table, indexed by chat, user and thread
  */
 package model
 
 import (
 	"errors"
-	"time"
 
 	pb "github.com/micro-community/micro-chat/proto"
 	"github.com/micro/dev/model"
 	"github.com/micro/micro/v3/service/store"
 )
 
+//ErrNotFound is returned when a message doesn't exist
+var ErrNotFound = model.ErrorNotFound
+
 //Repository for message
 type Repository struct {
 	Name      string
 	messsages model.Table
 }
 
+var (
+	// byChatOrder orders the messages in a chat by the time they were sent
+	byChatOrder = model.Order{FieldName: "SentAt", Type: model.OrderTypeAsc}
+	// byUserOrder orders the messages sent by a user by the time they were sent
+	byUserOrder = model.Order{FieldName: "SentAt", Type: model.OrderTypeAsc}
+	// byThreadOrder orders the replies in a thread by the time they were sent
+	byThreadOrder = model.Order{FieldName: "SentAt", Type: model.OrderTypeAsc}
+)
+
 //NewRepository return a message repo
 func NewRepository(repoName string) *Repository {
 
-	clientIndex := model.ByEquality("ClientId")
-	clientIndex.Unique = true
-	//	userIndex := model.ByEquality("UserId")
-	//	userIndex.Unique = true
+	// client ids are only unique per chat and user, so they aren't indexed. The messages are indexed
+	// by the chat, by the user who sent them and by the thread they reply in.
+	chatIndex := model.ByEquality("ChatId")
+	chatIndex.Order = byChatOrder
+	userIndex := model.ByEquality("UserId")
+	userIndex.Order = byUserOrder
+	threadIndex := model.ByEquality("ThreadRootId")
+	threadIndex.Order = byThreadOrder
 
 	return &Repository{
 		Name:      repoName,
-		messsages: model.NewTable(store.DefaultStore, repoName, model.Indexes(clientIndex), nil),
+		messsages: model.NewTable(store.DefaultStore, repoName, model.Indexes(chatIndex, userIndex, threadIndex), nil),
 	}
 }
 
-//Create for
-func (repo *Repository) Create(msg *pb.Message, salt string) error {
-	msg.SentAt = time.Now().Unix()
-	err := repo.messsages.Save(msg)
-	if err != nil {
-		return err
-	}
-	return repo.messsages.Save(pb.Message{
-		Id: msg.Id,
-	})
+//Create a message, the message is saved as is so it must already have been stamped with the time it
+//was sent
+func (repo *Repository) Create(msg *pb.Message) error {
+	return repo.messsages.Save(msg)
 }
 
 //Delete messages
@@ -53,9 +62,9 @@ func (repo *Repository) Delete(id string) error {
 	return repo.messsages.Delete(model.Equals("id", id))
 }
 
-//Update messages
+//Update a message, the time it was sent is left unchanged since it determines the order of the
+//messages in the chat
 func (repo *Repository) Update(msg *pb.Message) error {
-	msg.SentAt = time.Now().Unix()
 	return repo.messsages.Save(msg)
 }
 
@@ -65,16 +74,44 @@ func (repo *Repository) Read(id string) (*pb.Message, error) {
 	return messsage, repo.messsages.Read(model.Equals("id", id), messsage)
 }
 
-//Search messages
-func (repo *Repository) Search(username, email string, limit, offset int64) ([]*pb.Message, error) {
-	var query model.Query
-	if len(username) > 0 {
-		query = model.Equals("name", username)
-	} else if len(email) > 0 {
-		query = model.Equals("email", email)
-	} else {
-		return nil, errors.New("username and email cannot be blank")
+//ListByChat returns the messages in a chat, ordered by the time they were sent and then by their id,
+//skipping the first offset messages. A limit of zero returns all the messages after the offset
+func (repo *Repository) ListByChat(chatID string, limit, offset int64) ([]*pb.Message, error) {
+	if len(chatID) == 0 {
+		return nil, errors.New("chat id cannot be blank")
+	}
+	query := model.Equals("ChatId", chatID)
+	query.Order = byChatOrder
+	query.Limit = limit
+	query.Offset = offset
+
+	messsages := []*pb.Message{}
+	return messsages, repo.messsages.List(query, &messsages)
+}
+
+//ListByUser returns the messages sent by a user, ordered by the time they were sent. A limit of zero
+//returns all the messages
+func (repo *Repository) ListByUser(userID string, limit, offset int64) ([]*pb.Message, error) {
+	if len(userID) == 0 {
+		return nil, errors.New("user id cannot be blank")
+	}
+	query := model.Equals("UserId", userID)
+	query.Order = byUserOrder
+	query.Limit = limit
+	query.Offset = offset
+
+	messsages := []*pb.Message{}
+	return messsages, repo.messsages.List(query, &messsages)
+}
+
+//ListByThread returns the replies in a thread, ordered by the time they were sent. The first message
+//of the thread isn't a reply so it isn't returned
+func (repo *Repository) ListByThread(rootID string) ([]*pb.Message, error) {
+	if len(rootID) == 0 {
+		return nil, errors.New("thread root id cannot be blank")
 	}
+	query := model.Equals("ThreadRootId", rootID)
+	query.Order = byThreadOrder
 
 	messsages := []*pb.Message{}
 	return messsages, repo.messsages.List(query, &messsages)
@@ -44,8 +44,10 @@ func NewChatEndpoints() []*api.Endpoint {
 type ChatService interface {
 	//支持幂等调用的接口,对相同的人用户创建会话，默认总是返回同一个chat，除非指定强制创建为新会话的参数
 	New(ctx context.Context, in *NewRequest, opts ...client.CallOption) (*NewResponse, error)
-	//删除一个聊天，除非指定彻底销毁
+	//删除一个聊天，除非指定彻底销毁。未销毁的聊天只对删除者隐藏，可以通过Restore恢复
 	Remove(ctx context.Context, in *RemoveRequest, opts ...client.CallOption) (*RemoveResponse, error)
+	// 恢复一个被删除但未销毁的聊天
+	Restore(ctx context.Context, in *RestoreRequest, opts ...client.CallOption) (*RestoreResponse, error)
 	// 查询某个chat中的历史消息
 	History(ctx context.Context, in *HistoryRequest, opts ...client.CallOption) (*HistoryResponse, error)
 	// Send a single message to the chat，当发送消息给服务端时，消息会被添加到历史消息，同时转发给其他的连接用户。
@@ -53,6 +55,46 @@ type ChatService interface {
 	Send(ctx context.Context, in *SendRequest, opts ...client.CallOption) (*SendResponse, error)
 	// 双向stream的方式，连接到某一个会话(或者聊天室)，
 	Connect(ctx context.Context, opts ...client.CallOption) (Chat_ConnectService, error)
+	// 双向stream的方式连接到某一个会话，与Connect相同，但是传递的是ChatEvent，可以携带消息以外的实时事件
+	ConnectV2(ctx context.Context, opts ...client.CallOption) (Chat_ConnectV2Service, error)
+	// 查询某组用户之间的所有会话，包括通过forceNew创建的会话
+	ListByUsers(ctx context.Context, in *ListByUsersRequest, opts ...client.CallOption) (*ListByUsersResponse, error)
+	// 查询某个用户参与的所有会话，按最近活跃时间排序
+	ListChats(ctx context.Context, in *ListChatsRequest, opts ...client.CallOption) (*ListChatsResponse, error)
+	// 在用户参与的会话中按文本和主题搜索消息
+	Search(ctx context.Context, in *SearchRequest, opts ...client.CallOption) (*SearchResponse, error)
+	// 编辑自己发送的消息，之前的版本会被保留，并通知其他连接的用户
+	Edit(ctx context.Context, in *EditRequest, opts ...client.CallOption) (*EditResponse, error)
+	// 查询消息被编辑之前的所有版本
+	Revisions(ctx context.Context, in *RevisionsRequest, opts ...client.CallOption) (*RevisionsResponse, error)
+	// 撤回一条消息，消息内容会被清除，历史消息中只保留一个标记
+	DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...client.CallOption) (*DeleteMessageResponse, error)
+	// 查询用户是否在线，以及最后在线的时间
+	GetPresence(ctx context.Context, in *GetPresenceRequest, opts ...client.CallOption) (*GetPresenceResponse, error)
+	// 标记会话已读到某条消息，已读位置只会向前移动，并通知其他连接的用户
+	MarkRead(ctx context.Context, in *MarkReadRequest, opts ...client.CallOption) (*MarkReadResponse, error)
+	// 向会话中添加用户，会在历史消息中记录一条系统消息，并通知其他连接的用户
+	AddMembers(ctx context.Context, in *AddMembersRequest, opts ...client.CallOption) (*AddMembersResponse, error)
+	// 从会话中移除用户，会在历史消息中记录一条系统消息，并通知其他连接的用户
+	RemoveMembers(ctx context.Context, in *RemoveMembersRequest, opts ...client.CallOption) (*RemoveMembersResponse, error)
+	// 退出会话，最后一个用户退出时会话被销毁
+	Leave(ctx context.Context, in *LeaveRequest, opts ...client.CallOption) (*LeaveResponse, error)
+	// 设置会话中用户的角色，拥有者可以设置任何角色，管理员只能设置普通成员和只读成员
+	SetRole(ctx context.Context, in *SetRoleRequest, opts ...client.CallOption) (*SetRoleResponse, error)
+	// 将会话的所有权转移给另一个用户，原拥有者成为管理员
+	TransferOwnership(ctx context.Context, in *TransferOwnershipRequest, opts ...client.CallOption) (*TransferOwnershipResponse, error)
+	// 修改会话的标题、话题、头像和标签，只有拥有者和管理员可以修改，并通知其他连接的用户
+	UpdateChat(ctx context.Context, in *UpdateChatRequest, opts ...client.CallOption) (*UpdateChatResponse, error)
+	// 查询会话的信息
+	GetChat(ctx context.Context, in *GetChatRequest, opts ...client.CallOption) (*GetChatResponse, error)
+	// 创建一个公开的聊天室，聊天室通过唯一的名称标识，任何用户都可以加入
+	CreateRoom(ctx context.Context, in *CreateRoomRequest, opts ...client.CallOption) (*CreateRoomResponse, error)
+	// 查询公开的聊天室，可以按名称搜索
+	ListRooms(ctx context.Context, in *ListRoomsRequest, opts ...client.CallOption) (*ListRoomsResponse, error)
+	// 加入一个公开的聊天室
+	JoinRoom(ctx context.Context, in *JoinRoomRequest, opts ...client.CallOption) (*JoinRoomResponse, error)
+	// 查询一个话题，返回根消息以及所有的回复
+	GetThread(ctx context.Context, in *GetThreadRequest, opts ...client.CallOption) (*GetThreadResponse, error)
 }
 
 type chatService struct {
@@ -87,6 +129,16 @@ func (c *chatService) Remove(ctx context.Context, in *RemoveRequest, opts ...cli
 	return out, nil
 }
 
+func (c *chatService) Restore(ctx context.Context, in *RestoreRequest, opts ...client.CallOption) (*RestoreResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.Restore", in)
+	out := new(RestoreResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *chatService) History(ctx context.Context, in *HistoryRequest, opts ...client.CallOption) (*HistoryResponse, error) {
 	req := c.c.NewRequest(c.name, "Chat.History", in)
 	out := new(HistoryResponse)
@@ -158,13 +210,256 @@ func (x *chatServiceConnect) Recv() (*Message, error) {
 	return m, nil
 }
 
+func (c *chatService) ConnectV2(ctx context.Context, opts ...client.CallOption) (Chat_ConnectV2Service, error) {
+	req := c.c.NewRequest(c.name, "Chat.ConnectV2", &ChatEvent{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &chatServiceConnectV2{stream}, nil
+}
+
+type Chat_ConnectV2Service interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*ChatEvent) error
+	Recv() (*ChatEvent, error)
+}
+
+type chatServiceConnectV2 struct {
+	stream client.Stream
+}
+
+func (x *chatServiceConnectV2) Close() error {
+	return x.stream.Close()
+}
+
+func (x *chatServiceConnectV2) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *chatServiceConnectV2) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *chatServiceConnectV2) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *chatServiceConnectV2) Send(m *ChatEvent) error {
+	return x.stream.Send(m)
+}
+
+func (x *chatServiceConnectV2) Recv() (*ChatEvent, error) {
+	m := new(ChatEvent)
+	err := x.stream.Recv(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *chatService) ListByUsers(ctx context.Context, in *ListByUsersRequest, opts ...client.CallOption) (*ListByUsersResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.ListByUsers", in)
+	out := new(ListByUsersResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) ListChats(ctx context.Context, in *ListChatsRequest, opts ...client.CallOption) (*ListChatsResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.ListChats", in)
+	out := new(ListChatsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) Search(ctx context.Context, in *SearchRequest, opts ...client.CallOption) (*SearchResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.Search", in)
+	out := new(SearchResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) Edit(ctx context.Context, in *EditRequest, opts ...client.CallOption) (*EditResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.Edit", in)
+	out := new(EditResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) Revisions(ctx context.Context, in *RevisionsRequest, opts ...client.CallOption) (*RevisionsResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.Revisions", in)
+	out := new(RevisionsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...client.CallOption) (*DeleteMessageResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.DeleteMessage", in)
+	out := new(DeleteMessageResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) GetPresence(ctx context.Context, in *GetPresenceRequest, opts ...client.CallOption) (*GetPresenceResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.GetPresence", in)
+	out := new(GetPresenceResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) MarkRead(ctx context.Context, in *MarkReadRequest, opts ...client.CallOption) (*MarkReadResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.MarkRead", in)
+	out := new(MarkReadResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) AddMembers(ctx context.Context, in *AddMembersRequest, opts ...client.CallOption) (*AddMembersResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.AddMembers", in)
+	out := new(AddMembersResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) RemoveMembers(ctx context.Context, in *RemoveMembersRequest, opts ...client.CallOption) (*RemoveMembersResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.RemoveMembers", in)
+	out := new(RemoveMembersResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) Leave(ctx context.Context, in *LeaveRequest, opts ...client.CallOption) (*LeaveResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.Leave", in)
+	out := new(LeaveResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) SetRole(ctx context.Context, in *SetRoleRequest, opts ...client.CallOption) (*SetRoleResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.SetRole", in)
+	out := new(SetRoleResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) TransferOwnership(ctx context.Context, in *TransferOwnershipRequest, opts ...client.CallOption) (*TransferOwnershipResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.TransferOwnership", in)
+	out := new(TransferOwnershipResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) UpdateChat(ctx context.Context, in *UpdateChatRequest, opts ...client.CallOption) (*UpdateChatResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.UpdateChat", in)
+	out := new(UpdateChatResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) GetChat(ctx context.Context, in *GetChatRequest, opts ...client.CallOption) (*GetChatResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.GetChat", in)
+	out := new(GetChatResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) CreateRoom(ctx context.Context, in *CreateRoomRequest, opts ...client.CallOption) (*CreateRoomResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.CreateRoom", in)
+	out := new(CreateRoomResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) ListRooms(ctx context.Context, in *ListRoomsRequest, opts ...client.CallOption) (*ListRoomsResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.ListRooms", in)
+	out := new(ListRoomsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) JoinRoom(ctx context.Context, in *JoinRoomRequest, opts ...client.CallOption) (*JoinRoomResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.JoinRoom", in)
+	out := new(JoinRoomResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chatService) GetThread(ctx context.Context, in *GetThreadRequest, opts ...client.CallOption) (*GetThreadResponse, error) {
+	req := c.c.NewRequest(c.name, "Chat.GetThread", in)
+	out := new(GetThreadResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Chat service
 
 type ChatHandler interface {
 	//支持幂等调用的接口,对相同的人用户创建会话，默认总是返回同一个chat，除非指定强制创建为新会话的参数
 	New(context.Context, *NewRequest, *NewResponse) error
-	//删除一个聊天，除非指定彻底销毁
+	//删除一个聊天，除非指定彻底销毁。未销毁的聊天只对删除者隐藏，可以通过Restore恢复
 	Remove(context.Context, *RemoveRequest, *RemoveResponse) error
+	// 恢复一个被删除但未销毁的聊天
+	Restore(context.Context, *RestoreRequest, *RestoreResponse) error
 	// 查询某个chat中的历史消息
 	History(context.Context, *HistoryRequest, *HistoryResponse) error
 	// Send a single message to the chat，当发送消息给服务端时，消息会被添加到历史消息，同时转发给其他的连接用户。
@@ -172,15 +467,76 @@ type ChatHandler interface {
 	Send(context.Context, *SendRequest, *SendResponse) error
 	// 双向stream的方式，连接到某一个会话(或者聊天室)，
 	Connect(context.Context, Chat_ConnectStream) error
+	// 双向stream的方式连接到某一个会话，与Connect相同，但是传递的是ChatEvent，可以携带消息以外的实时事件
+	ConnectV2(context.Context, Chat_ConnectV2Stream) error
+	// 查询某组用户之间的所有会话，包括通过forceNew创建的会话
+	ListByUsers(context.Context, *ListByUsersRequest, *ListByUsersResponse) error
+	// 查询某个用户参与的所有会话，按最近活跃时间排序
+	ListChats(context.Context, *ListChatsRequest, *ListChatsResponse) error
+	// 在用户参与的会话中按文本和主题搜索消息
+	Search(context.Context, *SearchRequest, *SearchResponse) error
+	// 编辑自己发送的消息，之前的版本会被保留，并通知其他连接的用户
+	Edit(context.Context, *EditRequest, *EditResponse) error
+	// 查询消息被编辑之前的所有版本
+	Revisions(context.Context, *RevisionsRequest, *RevisionsResponse) error
+	// 撤回一条消息，消息内容会被清除，历史消息中只保留一个标记
+	DeleteMessage(context.Context, *DeleteMessageRequest, *DeleteMessageResponse) error
+	// 查询用户是否在线，以及最后在线的时间
+	GetPresence(context.Context, *GetPresenceRequest, *GetPresenceResponse) error
+	// 标记会话已读到某条消息，已读位置只会向前移动，并通知其他连接的用户
+	MarkRead(context.Context, *MarkReadRequest, *MarkReadResponse) error
+	// 向会话中添加用户，会在历史消息中记录一条系统消息，并通知其他连接的用户
+	AddMembers(context.Context, *AddMembersRequest, *AddMembersResponse) error
+	// 从会话中移除用户，会在历史消息中记录一条系统消息，并通知其他连接的用户
+	RemoveMembers(context.Context, *RemoveMembersRequest, *RemoveMembersResponse) error
+	// 退出会话，最后一个用户退出时会话被销毁
+	Leave(context.Context, *LeaveRequest, *LeaveResponse) error
+	// 设置会话中用户的角色，拥有者可以设置任何角色，管理员只能设置普通成员和只读成员
+	SetRole(context.Context, *SetRoleRequest, *SetRoleResponse) error
+	// 将会话的所有权转移给另一个用户，原拥有者成为管理员
+	TransferOwnership(context.Context, *TransferOwnershipRequest, *TransferOwnershipResponse) error
+	// 修改会话的标题、话题、头像和标签，只有拥有者和管理员可以修改，并通知其他连接的用户
+	UpdateChat(context.Context, *UpdateChatRequest, *UpdateChatResponse) error
+	// 查询会话的信息
+	GetChat(context.Context, *GetChatRequest, *GetChatResponse) error
+	// 创建一个公开的聊天室，聊天室通过唯一的名称标识，任何用户都可以加入
+	CreateRoom(context.Context, *CreateRoomRequest, *CreateRoomResponse) error
+	// 查询公开的聊天室，可以按名称搜索
+	ListRooms(context.Context, *ListRoomsRequest, *ListRoomsResponse) error
+	// 加入一个公开的聊天室
+	JoinRoom(context.Context, *JoinRoomRequest, *JoinRoomResponse) error
+	// 查询一个话题，返回根消息以及所有的回复
+	GetThread(context.Context, *GetThreadRequest, *GetThreadResponse) error
 }
 
 func RegisterChatHandler(s server.Server, hdlr ChatHandler, opts ...server.HandlerOption) error {
 	type chat interface {
 		New(ctx context.Context, in *NewRequest, out *NewResponse) error
 		Remove(ctx context.Context, in *RemoveRequest, out *RemoveResponse) error
+		Restore(ctx context.Context, in *RestoreRequest, out *RestoreResponse) error
 		History(ctx context.Context, in *HistoryRequest, out *HistoryResponse) error
 		Send(ctx context.Context, in *SendRequest, out *SendResponse) error
 		Connect(ctx context.Context, stream server.Stream) error
+		ConnectV2(ctx context.Context, stream server.Stream) error
+		ListByUsers(ctx context.Context, in *ListByUsersRequest, out *ListByUsersResponse) error
+		ListChats(ctx context.Context, in *ListChatsRequest, out *ListChatsResponse) error
+		Search(ctx context.Context, in *SearchRequest, out *SearchResponse) error
+		Edit(ctx context.Context, in *EditRequest, out *EditResponse) error
+		Revisions(ctx context.Context, in *RevisionsRequest, out *RevisionsResponse) error
+		DeleteMessage(ctx context.Context, in *DeleteMessageRequest, out *DeleteMessageResponse) error
+		GetPresence(ctx context.Context, in *GetPresenceRequest, out *GetPresenceResponse) error
+		MarkRead(ctx context.Context, in *MarkReadRequest, out *MarkReadResponse) error
+		AddMembers(ctx context.Context, in *AddMembersRequest, out *AddMembersResponse) error
+		RemoveMembers(ctx context.Context, in *RemoveMembersRequest, out *RemoveMembersResponse) error
+		Leave(ctx context.Context, in *LeaveRequest, out *LeaveResponse) error
+		SetRole(ctx context.Context, in *SetRoleRequest, out *SetRoleResponse) error
+		TransferOwnership(ctx context.Context, in *TransferOwnershipRequest, out *TransferOwnershipResponse) error
+		UpdateChat(ctx context.Context, in *UpdateChatRequest, out *UpdateChatResponse) error
+		GetChat(ctx context.Context, in *GetChatRequest, out *GetChatResponse) error
+		CreateRoom(ctx context.Context, in *CreateRoomRequest, out *CreateRoomResponse) error
+		ListRooms(ctx context.Context, in *ListRoomsRequest, out *ListRoomsResponse) error
+		JoinRoom(ctx context.Context, in *JoinRoomRequest, out *JoinRoomResponse) error
+		GetThread(ctx context.Context, in *GetThreadRequest, out *GetThreadResponse) error
 	}
 	type Chat struct {
 		chat
@@ -201,6 +557,10 @@ func (h *chatHandler) Remove(ctx context.Context, in *RemoveRequest, out *Remove
 	return h.ChatHandler.Remove(ctx, in, out)
 }
 
+func (h *chatHandler) Restore(ctx context.Context, in *RestoreRequest, out *RestoreResponse) error {
+	return h.ChatHandler.Restore(ctx, in, out)
+}
+
 func (h *chatHandler) History(ctx context.Context, in *HistoryRequest, out *HistoryResponse) error {
 	return h.ChatHandler.History(ctx, in, out)
 }
@@ -253,3 +613,124 @@ func (x *chatConnectStream) Recv() (*Message, error) {
 	}
 	return m, nil
 }
+
+func (h *chatHandler) ConnectV2(ctx context.Context, stream server.Stream) error {
+	return h.ChatHandler.ConnectV2(ctx, &chatConnectV2Stream{stream})
+}
+
+type Chat_ConnectV2Stream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*ChatEvent) error
+	Recv() (*ChatEvent, error)
+}
+
+type chatConnectV2Stream struct {
+	stream server.Stream
+}
+
+func (x *chatConnectV2Stream) Close() error {
+	return x.stream.Close()
+}
+
+func (x *chatConnectV2Stream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *chatConnectV2Stream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *chatConnectV2Stream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *chatConnectV2Stream) Send(m *ChatEvent) error {
+	return x.stream.Send(m)
+}
+
+func (x *chatConnectV2Stream) Recv() (*ChatEvent, error) {
+	m := new(ChatEvent)
+	if err := x.stream.Recv(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (h *chatHandler) ListByUsers(ctx context.Context, in *ListByUsersRequest, out *ListByUsersResponse) error {
+	return h.ChatHandler.ListByUsers(ctx, in, out)
+}
+
+func (h *chatHandler) ListChats(ctx context.Context, in *ListChatsRequest, out *ListChatsResponse) error {
+	return h.ChatHandler.ListChats(ctx, in, out)
+}
+
+func (h *chatHandler) Search(ctx context.Context, in *SearchRequest, out *SearchResponse) error {
+	return h.ChatHandler.Search(ctx, in, out)
+}
+
+func (h *chatHandler) Edit(ctx context.Context, in *EditRequest, out *EditResponse) error {
+	return h.ChatHandler.Edit(ctx, in, out)
+}
+
+func (h *chatHandler) Revisions(ctx context.Context, in *RevisionsRequest, out *RevisionsResponse) error {
+	return h.ChatHandler.Revisions(ctx, in, out)
+}
+
+func (h *chatHandler) DeleteMessage(ctx context.Context, in *DeleteMessageRequest, out *DeleteMessageResponse) error {
+	return h.ChatHandler.DeleteMessage(ctx, in, out)
+}
+
+func (h *chatHandler) GetPresence(ctx context.Context, in *GetPresenceRequest, out *GetPresenceResponse) error {
+	return h.ChatHandler.GetPresence(ctx, in, out)
+}
+
+func (h *chatHandler) MarkRead(ctx context.Context, in *MarkReadRequest, out *MarkReadResponse) error {
+	return h.ChatHandler.MarkRead(ctx, in, out)
+}
+
+func (h *chatHandler) AddMembers(ctx context.Context, in *AddMembersRequest, out *AddMembersResponse) error {
+	return h.ChatHandler.AddMembers(ctx, in, out)
+}
+
+func (h *chatHandler) RemoveMembers(ctx context.Context, in *RemoveMembersRequest, out *RemoveMembersResponse) error {
+	return h.ChatHandler.RemoveMembers(ctx, in, out)
+}
+
+func (h *chatHandler) Leave(ctx context.Context, in *LeaveRequest, out *LeaveResponse) error {
+	return h.ChatHandler.Leave(ctx, in, out)
+}
+
+func (h *chatHandler) SetRole(ctx context.Context, in *SetRoleRequest, out *SetRoleResponse) error {
+	return h.ChatHandler.SetRole(ctx, in, out)
+}
+
+func (h *chatHandler) TransferOwnership(ctx context.Context, in *TransferOwnershipRequest, out *TransferOwnershipResponse) error {
+	return h.ChatHandler.TransferOwnership(ctx, in, out)
+}
+
+func (h *chatHandler) UpdateChat(ctx context.Context, in *UpdateChatRequest, out *UpdateChatResponse) error {
+	return h.ChatHandler.UpdateChat(ctx, in, out)
+}
+
+func (h *chatHandler) GetChat(ctx context.Context, in *GetChatRequest, out *GetChatResponse) error {
+	return h.ChatHandler.GetChat(ctx, in, out)
+}
+
+func (h *chatHandler) CreateRoom(ctx context.Context, in *CreateRoomRequest, out *CreateRoomResponse) error {
+	return h.ChatHandler.CreateRoom(ctx, in, out)
+}
+
+func (h *chatHandler) ListRooms(ctx context.Context, in *ListRoomsRequest, out *ListRoomsResponse) error {
+	return h.ChatHandler.ListRooms(ctx, in, out)
+}
+
+func (h *chatHandler) JoinRoom(ctx context.Context, in *JoinRoomRequest, out *JoinRoomResponse) error {
+	return h.ChatHandler.JoinRoom(ctx, in, out)
+}
+
+func (h *chatHandler) GetThread(ctx context.Context, in *GetThreadRequest, out *GetThreadResponse) error {
+	return h.ChatHandler.GetThread(ctx, in, out)
+}